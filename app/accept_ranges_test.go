@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestAcceptRanges(t *testing.T) {
+	for _, ranges := range []bool{true, false} {
+		cfg := testConfig(t)
+		cfg.ranges = ranges
+		writeFile(t, cfg, "a.txt", "hello")
+
+		want := "bytes"
+		if !ranges {
+			want = "none"
+		}
+
+		for _, method := range []string{"GET", "HEAD"} {
+			output, _ := exchange(t, cfg, method+" /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			response := parseResponses(t, output, method)[0]
+
+			if response.status != 200 || response.header.Get("Accept-Ranges") != want {
+				t.Errorf("%s with -ranges=%v: got %d with Accept-Ranges %q, want 200 with %q", method, ranges, response.status, response.header.Get("Accept-Ranges"), want)
+			}
+		}
+	}
+}
+
+func TestAcceptRangesOnPartialContent(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "a.txt", "hello")
+
+	response := roundTrip(t, cfg, "GET /files/a.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=1-2\r\n\r\n")
+
+	if response.status != 206 || response.header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("got %d with Accept-Ranges %q, want 206 with bytes", response.status, response.header.Get("Accept-Ranges"))
+	}
+}
+
+func TestAcceptRangesOnlyForFiles(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "GET /echo/hello HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if got := response.header.Get("Accept-Ranges"); got != "" {
+		t.Errorf("echo response advertised Accept-Ranges %q", got)
+	}
+}
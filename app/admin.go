@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// adminAuthorized reports whether request carries the -admin-token in its
+// X-Admin-Token header. It always fails when no token is configured.
+func (c *connection) adminAuthorized(request *request) bool {
+	token, ok := request.headers["X-Admin-Token"]
+	if !ok || c.adminToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.adminToken)) == 1
+}
+
+// clearFiles answers a DELETE of /files itself by removing every file in
+// filesDir, a reset for test setups that needs the -admin-token. Directories
+// are only removed with X-Recursive: true. filesDir itself is kept, and
+// symlinks are removed as links, never followed.
+func (c *connection) clearFiles(ctx context.Context, request *request) error {
+	if !c.adminAuthorized(request) {
+		return newHTTPError(forbidden, "clearing the files directory requires the admin token")
+	}
+
+	entries, err := os.ReadDir(c.filesDir)
+	if err != nil {
+		return &httpError{status: internal_server_error, message: "unable to list files", err: err}
+	}
+
+	recursive := strings.EqualFold(request.headers["X-Recursive"], "true")
+
+	// parts of resumable uploads are removed along with the files, their
+	// clients start over
+	c.uploads.reset()
+
+	removed := 0
+	for _, entry := range entries {
+		remove := os.Remove
+
+		// the entry's type is that of the link for a symlink, so a link to a
+		// directory is removed like the file it is
+		if entry.IsDir() {
+			if !recursive {
+				continue
+			}
+
+			remove = os.RemoveAll
+		}
+
+		if err := remove(filepath.Join(c.filesDir, entry.Name())); err != nil {
+			c.rescanQuota()
+
+			err = fmt.Errorf("failed to clear %s: %w", c.filesDir, err)
+			if errors.Is(err, fs.ErrPermission) {
+				return &httpError{status: forbidden, message: "file is not deletable", err: err}
+			}
+
+			return &httpError{status: internal_server_error, message: "unable to delete file", err: err}
+		}
+
+		removed++
+	}
+
+	c.rescanQuota()
+
+	logger.infof("Cleared %d entries from %s for request %s", removed, c.filesDir, requestID(ctx))
+
+	if err := c.send(ctx, buildResponse(no_content, nil, "")); err != nil {
+		return fmt.Errorf("failed to send NO CONTENT response for DELETE request: %w", err)
+	}
+
+	return nil
+}
+
+// rescanQuota recomputes the quota usage after files were removed in bulk.
+func (c *connection) rescanQuota() {
+	if c.quota == nil {
+		return
+	}
+
+	if err := c.quota.rescan(); err != nil {
+		logger.warnf("Failed to recompute storage quota usage: %v", err)
+	}
+}
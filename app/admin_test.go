@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClearFilesRequiresAdminToken(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.adminToken = "secret"
+	kept := writeFile(t, cfg, "kept.txt", "still here")
+
+	for name, header := range map[string]string{"missing": "", "wrong": "X-Admin-Token: guess\r\n"} {
+		t.Run(name, func(t *testing.T) {
+			response := roundTrip(t, cfg, "DELETE /files/ HTTP/1.1\r\nHost: localhost\r\n"+header+"\r\n")
+
+			if response.status != 403 {
+				t.Errorf("got status %d, want 403", response.status)
+			}
+
+			if _, err := os.Stat(kept); err != nil {
+				t.Errorf("file removed without the token: %v", err)
+			}
+		})
+	}
+}
+
+func TestClearFilesKeepsDirectoriesUnlessRecursive(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.adminToken = "secret"
+	writeFile(t, cfg, "top.txt", "a")
+	nested := writeFile(t, cfg, "sub/nested.txt", "b")
+
+	response := roundTrip(t, cfg, "DELETE /files HTTP/1.1\r\nHost: localhost\r\nX-Admin-Token: secret\r\n\r\n")
+	if response.status != 204 {
+		t.Fatalf("got status %d, want 204", response.status)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.filesDir, "top.txt")); !os.IsNotExist(err) {
+		t.Errorf("top level file still there: %v", err)
+	}
+
+	if _, err := os.Stat(nested); err != nil {
+		t.Errorf("directory removed without X-Recursive: %v", err)
+	}
+
+	response = roundTrip(t, cfg, "DELETE /files HTTP/1.1\r\nHost: localhost\r\nX-Admin-Token: secret\r\nX-Recursive: true\r\n\r\n")
+	if response.status != 204 {
+		t.Fatalf("got status %d for the recursive clear, want 204", response.status)
+	}
+
+	entries, err := os.ReadDir(cfg.filesDir)
+	if err != nil {
+		t.Fatalf("files directory itself removed: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("%d entries left after a recursive clear", len(entries))
+	}
+}
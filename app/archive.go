@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var errOutsideFilesDir = errors.New("path resolves outside of the files directory")
+
+// resolvePath maps a slash separated path from a request onto filesDir. Any
+// ".." elements are resolved against the root so the result never climbs
+// above filesDir, and symlinks are evaluated so that a link pointing outside
+// of filesDir is rejected with errOutsideFilesDir.
+func resolvePath(filesDir string, requestPath string) (string, error) {
+	root, err := filepath.EvalSymlinks(filesDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve files directory: %w", err)
+	}
+
+	target := filepath.Join(root, filepath.FromSlash(filepath.Clean("/"+requestPath)))
+
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", errOutsideFilesDir
+	}
+
+	return resolved, nil
+}
+
+// handleArchive streams a gzip compressed tarball of a directory under
+// filesDir, or of the -zip archive when /files is served from one. The size
+// isn't known up front so the body is sent chunked, with the SHA-256 of the
+// archive in a trailer for clients that sent TE: trailers.
+func (c *connection) handleArchive(ctx context.Context, request *request, requestPath string) error {
+	var (
+		name     string
+		writeAll func(tw *tar.Writer) error
+		err      error
+	)
+
+	if c.zipPath != "" {
+		name, writeAll, err = c.zipArchiveSource(ctx, requestPath)
+	} else {
+		name, writeAll, err = c.dirArchiveSource(ctx, requestPath)
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return newHTTPError(not_found, "")
+	}
+
+	// a directory outside filesDir isn't there as far as the client is
+	// concerned, but it's worth logging
+	if err != nil {
+		return &httpError{status: not_found, err: fmt.Errorf("failed to resolve archive directory %s: %w", requestPath, err)}
+	}
+
+	headers := []string{
+		"Content-Type: application/gzip",
+		fmt.Sprintf("Content-Disposition: attachment; filename=\"%s.tar.gz\"", name),
+		"Transfer-Encoding: chunked",
+	}
+
+	if request.acceptsTrailers {
+		headers = append(headers, "Trailer: X-Content-SHA256")
+	}
+
+	if err := c.send(ctx, buildResponse(ok, &headers, "")); err != nil {
+		return fmt.Errorf("failed to send archive response headers: %w", err)
+	}
+
+	if strings.Split(request.protocol, " ")[0] == "HEAD" {
+		return nil
+	}
+
+	chunked := newChunkedWriter(ctx, c)
+	digest := sha256.New()
+
+	// batch the small writes made by gzip into reasonably sized chunks
+	buffered := bufio.NewWriterSize(io.MultiWriter(chunked, digest), 32*1024)
+	gz := gzip.NewWriter(buffered)
+	tw := tar.NewWriter(gz)
+
+	if err := writeAll(tw); err != nil {
+		return fmt.Errorf("failed to stream archive of %s: %w", requestPath, err)
+	}
+
+	for _, closer := range []io.Closer{tw, gz} {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("failed to finish archive of %s: %w", requestPath, err)
+		}
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to send archive of %s: %w", requestPath, err)
+	}
+
+	if !request.acceptsTrailers {
+		return chunked.Close()
+	}
+
+	// the checksum of the archive as sent is only known once it's complete
+	return chunked.closeWithTrailers([]string{
+		"X-Content-SHA256: " + hex.EncodeToString(digest.Sum(nil)),
+	})
+}
+
+// dirArchiveSource resolves requestPath to a directory under filesDir,
+// returning its name and a function writing its contents to a tarball.
+func (c *connection) dirArchiveSource(ctx context.Context, requestPath string) (string, func(tw *tar.Writer) error, error) {
+	dir, err := resolvePath(c.filesDir, requestPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !info.IsDir() {
+		return "", nil, os.ErrNotExist
+	}
+
+	return filepath.Base(dir), func(tw *tar.Writer) error { return writeTar(ctx, tw, dir) }, nil
+}
+
+// zipArchiveSource is dirArchiveSource for a directory inside the -zip
+// archive. The archive root is named after the zip file.
+func (c *connection) zipArchiveSource(ctx context.Context, requestPath string) (string, func(tw *tar.Writer) error, error) {
+	dir := fsPath(requestPath)
+
+	info, err := fs.Stat(c.files, dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !info.IsDir() {
+		return "", nil, fs.ErrNotExist
+	}
+
+	name := path.Base(dir)
+	if dir == "." {
+		name = strings.TrimSuffix(filepath.Base(c.zipPath), filepath.Ext(c.zipPath))
+	}
+
+	return name, func(tw *tar.Writer) error { return writeTarFS(ctx, tw, c.files, dir) }, nil
+}
+
+// writeTar adds the regular files and directories under dir to tw, giving up
+// once ctx is done. Symlinks are skipped rather than followed so the archive
+// can't escape dir.
+func writeTar(ctx context.Context, tw *tar.Writer, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == dir || !(info.Mode().IsRegular() || info.IsDir()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		_, err = ctxCopy(ctx, tw, file, defaultStreamChunkSize)
+		return err
+	})
+}
+
+// writeTarFS is writeTar for the directory dir in files.
+func writeTarFS(ctx context.Context, tw *tar.Writer, files fs.FS, dir string) error {
+	return fs.WalkDir(files, dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name == dir || !(entry.Type().IsRegular() || entry.IsDir()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		header.Name = strings.TrimPrefix(name, dir+"/")
+		if dir == "." {
+			header.Name = name
+		}
+
+		if entry.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		file, err := files.Open(name)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		_, err = ctxCopy(ctx, tw, file, defaultStreamChunkSize)
+		return err
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tarEntries returns the contents of the gzip compressed tarball body by
+// entry name, with "" for directories.
+func tarEntries(t *testing.T, body string) map[string]string {
+	t.Helper()
+
+	gz, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("archive isn't gzip compressed: %v", err)
+	}
+
+	entries := map[string]string{}
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s from archive: %v", header.Name, err)
+		}
+
+		entries[header.Name] = string(content)
+	}
+}
+
+// zipConfig returns a config serving /files from a zip archive of files.
+func zipConfig(t *testing.T, files map[string]string) *config {
+	t.Helper()
+
+	cfg := testConfig(t)
+	cfg.zipPath = filepath.Join(t.TempDir(), "site.zip")
+
+	out, err := os.Create(cfg.zipPath)
+	if err != nil {
+		t.Fatalf("failed to create zip archive: %v", err)
+	}
+
+	zw := zip.NewWriter(out)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s to zip archive: %v", name, err)
+		}
+
+		io.WriteString(w, content)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to write zip archive: %v", err)
+	}
+
+	out.Close()
+
+	archive, err := openZip(cfg.zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { archive.Close() })
+	cfg.files = archive
+
+	return cfg
+}
+
+func TestArchiveDirectory(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "docs/a.txt", "alpha")
+	writeFile(t, cfg, "docs/sub/b.txt", "beta")
+	writeFile(t, cfg, "other.txt", "not included")
+
+	response := roundTrip(t, cfg, "GET /archive/docs HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 200 {
+		t.Fatalf("got status %d, want 200", response.status)
+	}
+
+	if got := response.header.Get("Content-Disposition"); got != `attachment; filename="docs.tar.gz"` {
+		t.Errorf("got Content-Disposition %q", got)
+	}
+
+	want := map[string]string{"a.txt": "alpha", "sub/": "", "sub/b.txt": "beta"}
+	if got := tarEntries(t, response.body); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+}
+
+func TestArchiveFromZip(t *testing.T) {
+	cfg := zipConfig(t, map[string]string{
+		"index.html":     "<html></html>",
+		"docs/a.txt":     "alpha",
+		"docs/sub/b.txt": "beta",
+	})
+
+	response := roundTrip(t, cfg, "GET /archive/docs HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 200 {
+		t.Fatalf("got status %d, want 200", response.status)
+	}
+
+	want := map[string]string{"a.txt": "alpha", "sub/": "", "sub/b.txt": "beta"}
+	if got := tarEntries(t, response.body); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+
+	// the root of the zip is named after the archive
+	response = roundTrip(t, cfg, "GET /archive/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if got := response.header.Get("Content-Disposition"); got != `attachment; filename="site.tar.gz"` {
+		t.Errorf("got Content-Disposition %q for the root", got)
+	}
+
+	if got := tarEntries(t, response.body); got["index.html"] != "<html></html>" || got["docs/sub/b.txt"] != "beta" {
+		t.Errorf("got root entries %v", got)
+	}
+}
+
+func TestArchiveNotFound(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "a.txt", "alpha")
+
+	zipped := zipConfig(t, map[string]string{"a.txt": "alpha"})
+
+	for _, path := range []string{"/archive/missing", "/archive/a.txt", "/archive/missing/a.txt"} {
+		if response := roundTrip(t, cfg, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n"); response.status != 404 {
+			t.Errorf("got status %d for %s, want 404", response.status, path)
+		}
+
+		if response := roundTrip(t, zipped, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n"); response.status != 404 {
+			t.Errorf("got status %d for %s from a zip, want 404", response.status, path)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Authenticator decides whether a set of basic auth credentials is valid.
+// main picks the implementation, handlers only see this interface.
+type Authenticator interface {
+	Authenticate(user, pass string) bool
+}
+
+// schemeAuthenticator is an Authenticator for an Authorization scheme other
+// than Basic. credentials takes what is passed to Authenticate from the
+// request, and challenge is the WWW-Authenticate value sent when the request
+// isn't authenticated.
+type schemeAuthenticator interface {
+	Authenticator
+	credentials(request *request) (user string, pass string, ok bool)
+	challenge() string
+}
+
+// basicChallenge asks for basic auth credentials.
+const basicChallenge = `Basic realm="http-server"`
+
+// staticAuthenticator accepts a single user and password.
+type staticAuthenticator struct {
+	user string
+	pass string
+}
+
+func newStaticAuthenticator(credentials string) (*staticAuthenticator, error) {
+	user, pass, found := strings.Cut(credentials, ":")
+	if !found || user == "" {
+		return nil, fmt.Errorf("invalid credentials, expected user:password")
+	}
+
+	return &staticAuthenticator{user: user, pass: pass}, nil
+}
+
+func (a *staticAuthenticator) Authenticate(user, pass string) bool {
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(a.user))
+	passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(a.pass))
+
+	return userMatch&passMatch == 1
+}
+
+// htpasswdAuthenticator checks credentials against the users of an
+// htpasswd-style file. Only {SHA} hashes (htpasswd -s) are supported, bcrypt
+// needs golang.org/x/crypto which this module doesn't depend on.
+type htpasswdAuthenticator struct {
+	hashes map[string]string
+}
+
+func newHtpasswdAuthenticator(path string) (*htpasswdAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open auth file: %w", err)
+	}
+
+	defer file.Close()
+
+	hashes := make(map[string]string)
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, lineNumber)
+		}
+
+		// htpasswd -B writes bcrypt by default, say why such a file can't be
+		// used rather than just that the hash isn't {SHA}
+		if isBcryptHash(hash) {
+			return nil, fmt.Errorf("%s:%d: bcrypt hash for user %s isn't supported, recreate it with htpasswd -s for a {SHA} hash", path, lineNumber, user)
+		}
+
+		if !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for user %s, only {SHA} hashes are supported", path, lineNumber, user)
+		}
+
+		hashes[user] = strings.TrimPrefix(hash, "{SHA}")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %w", err)
+	}
+
+	return &htpasswdAuthenticator{hashes: hashes}, nil
+}
+
+// isBcryptHash reports whether hash is in the modular crypt format of bcrypt,
+// as written by htpasswd -B.
+func isBcryptHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2x$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *htpasswdAuthenticator) Authenticate(user, pass string) bool {
+	hash, ok := a.hashes[user]
+	if !ok {
+		return false
+	}
+
+	digest := sha1.Sum([]byte(pass))
+	computed := base64.StdEncoding.EncodeToString(digest[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// bearerAuthenticator accepts requests with a single bearer token. The token
+// is passed to Authenticate as the password, there's no user.
+type bearerAuthenticator struct {
+	token string
+}
+
+func newBearerAuthenticator(token string) (*bearerAuthenticator, error) {
+	if strings.TrimSpace(token) != token || strings.ContainsAny(token, " \t") {
+		return nil, fmt.Errorf("invalid bearer token, it may not contain whitespace")
+	}
+
+	return &bearerAuthenticator{token: token}, nil
+}
+
+func (a *bearerAuthenticator) Authenticate(_, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *bearerAuthenticator) credentials(request *request) (string, string, bool) {
+	scheme, token, found := strings.Cut(request.headers["Authorization"], " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", "", false
+	}
+
+	return "", strings.TrimSpace(token), true
+}
+
+func (a *bearerAuthenticator) challenge() string {
+	return "Bearer"
+}
+
+// basicAuth extracts the credentials from a basic Authorization header.
+func basicAuth(request *request) (user string, pass string, ok bool) {
+	scheme, encoded, found := strings.Cut(request.headers["Authorization"], " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
+
+// authenticate returns the user whose credentials request carries, provided
+// the configured authenticator accepts them. It always fails when no
+// authenticator is configured.
+func (c *connection) authenticate(request *request) (string, bool) {
+	if c.authenticator == nil {
+		return "", false
+	}
+
+	credentials := basicAuth
+	if scheme, ok := c.authenticator.(schemeAuthenticator); ok {
+		credentials = scheme.credentials
+	}
+
+	user, pass, ok := credentials(request)
+	if !ok || !c.authenticator.Authenticate(user, pass) {
+		return "", false
+	}
+
+	return user, true
+}
+
+// publicPaths are answered without authentication, orchestrators probing
+// them don't have credentials.
+var publicPaths = map[string]bool{
+	"/livez":  true,
+	"/readyz": true,
+}
+
+// requiresAuth reports whether a request for target has to be authenticated.
+func (c *connection) requiresAuth(target string) bool {
+	path, _, _ := strings.Cut(target, "?")
+	return c.authenticator != nil && !publicPaths[path]
+}
+
+// challenge returns the WWW-Authenticate value for an unauthenticated request.
+func (c *connection) challenge() string {
+	if scheme, ok := c.authenticator.(schemeAuthenticator); ok {
+		return scheme.challenge()
+	}
+
+	return basicChallenge
+}
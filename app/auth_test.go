@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeHtpasswd(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write htpasswd file: %v", err)
+	}
+
+	return path
+}
+
+func TestHtpasswdRejectsBcrypt(t *testing.T) {
+	for _, hash := range []string{
+		"$2y$05$TsCJbCaU9TMqSos6A5PkYu8C8TYQGtbp5H6bLfzQgQ9tjKh9kEvuK",
+		"$2a$10$abcdefghijklmnopqrstuuH8rU8BfRaPjIdatXzq32cALEAymWrhK",
+		"$2b$12$abcdefghijklmnopqrstuuH8rU8BfRaPjIdatXzq32cALEAymWrhK",
+	} {
+		path := writeHtpasswd(t, "alice:{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=", "bob:"+hash)
+
+		_, err := newHtpasswdAuthenticator(path)
+		if err == nil || !strings.Contains(err.Error(), "bcrypt") {
+			t.Errorf("got %v for a %s hash, want a bcrypt error", err, hash[:4])
+		}
+	}
+}
+
+func TestHtpasswdSHA(t *testing.T) {
+	// {SHA} of "test", as written by htpasswd -s
+	path := writeHtpasswd(t, "# users", "", "alice:{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=")
+
+	authenticator, err := newHtpasswdAuthenticator(path)
+	if err != nil {
+		t.Fatalf("failed to load htpasswd file: %v", err)
+	}
+
+	if !authenticator.Authenticate("alice", "test") {
+		t.Errorf("valid password rejected")
+	}
+
+	if authenticator.Authenticate("alice", "wrong") || authenticator.Authenticate("bob", "test") {
+		t.Errorf("invalid credentials accepted")
+	}
+}
+
+func TestBasicAuthChallenge(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.authenticator = &staticAuthenticator{user: "alice", pass: "secret"}
+
+	response := roundTrip(t, cfg, "GET /echo/x HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 401 {
+		t.Fatalf("got status %d without credentials, want 401", response.status)
+	}
+
+	if got := response.header.Get("WWW-Authenticate"); got != basicChallenge {
+		t.Errorf("got WWW-Authenticate %q, want %q", got, basicChallenge)
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+
+	response = roundTrip(t, cfg, "GET /echo/x HTTP/1.1\r\nHost: localhost\r\nauthorization: basic "+credentials+"\r\n\r\n")
+	if response.status != 200 {
+		t.Errorf("got status %d with valid credentials, want 200", response.status)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.authenticator = &bearerAuthenticator{token: "t0ken"}
+
+	tests := map[string]int{
+		"":                                  401,
+		"Authorization: Bearer wrong\r\n":   401,
+		"Authorization: Basic dDA6a2Vu\r\n": 401,
+		"Authorization: Bearer t0ken\r\n":   200,
+		"Authorization: bearer  t0ken \r\n": 200,
+	}
+
+	for header, status := range tests {
+		response := roundTrip(t, cfg, "GET /echo/x HTTP/1.1\r\nHost: localhost\r\n"+header+"\r\n")
+		if response.status != status {
+			t.Errorf("got status %d with %q, want %d", response.status, header, status)
+		}
+
+		if status == 401 && response.header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("got WWW-Authenticate %q, want Bearer", response.header.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestHealthChecksSkipAuthentication(t *testing.T) {
+	cfg := testConfig(t)
+
+	var err error
+	if cfg.authenticator, err = newStaticAuthenticator("admin:secret"); err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	for _, path := range []string{"/livez", "/readyz", "/livez?probe=1"} {
+		response := roundTrip(t, cfg, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		if response.status == 401 {
+			t.Errorf("%s asked for credentials", path)
+		}
+	}
+
+	response := roundTrip(t, cfg, "GET /echo/private HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 401 || response.header.Get("WWW-Authenticate") == "" {
+		t.Errorf("got %d for /echo without credentials, want 401 with a challenge", response.status)
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("admin:secret"))
+	response = roundTrip(t, cfg, "GET /echo/private HTTP/1.1\r\nHost: localhost\r\nAuthorization: Basic "+credentials+"\r\n\r\n")
+	if response.status != 200 || response.body != "private" {
+		t.Errorf("got %d %q with credentials, want 200 \"private\"", response.status, response.body)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// logBanner logs the effective configuration the server starts with. The
+// first line is always logged, verbose adds one line per setting.
+func (cfg *config) logBanner(addr string, verbose bool) {
+	features := cfg.features()
+
+	enabled := "none"
+	if len(features) != 0 {
+		enabled = strings.Join(features, ", ")
+	}
+
+	served := cfg.filesDir
+	if cfg.zipPath != "" {
+		served = cfg.zipPath
+	}
+
+	logger.infof("Serving %s on %s, features: %s", served, addr, enabled)
+
+	if !verbose {
+		return
+	}
+
+	for _, setting := range cfg.settings() {
+		logger.infof("  %s", setting)
+	}
+}
+
+// features lists the optional behaviours that are switched on.
+func (cfg *config) features() []string {
+	var features []string
+
+	if cfg.authenticator != nil {
+		features = append(features, "auth")
+	}
+
+	if cfg.ranges {
+		features = append(features, "ranges")
+	}
+
+	if cfg.gzip {
+		features = append(features, "gzip")
+	}
+
+	if cfg.emptyAs204 {
+		features = append(features, "empty-as-204")
+	}
+
+	if cfg.trustProxy {
+		features = append(features, "trust-proxy")
+	}
+
+	if !cfg.disableTrace {
+		features = append(features, "trace")
+	}
+
+	if cfg.quota != nil {
+		features = append(features, "quota")
+	}
+
+	if cfg.idempotency != nil {
+		features = append(features, "idempotency")
+	}
+
+	if cfg.spaEntry != "" {
+		features = append(features, "spa")
+	}
+
+	if cfg.upstream != nil {
+		features = append(features, "proxy")
+	}
+
+	if cfg.zipPath != "" {
+		features = append(features, "zip")
+	}
+
+	if cfg.stdinName != "" {
+		features = append(features, "serve-stdin")
+	}
+
+	if len(cfg.rules) != 0 {
+		features = append(features, "rules")
+	}
+
+	if cfg.latency.max > 0 {
+		features = append(features, "inject-latency")
+	}
+
+	if cfg.adminToken != "" {
+		features = append(features, "admin")
+	}
+
+	if cfg.preloaded != nil {
+		features = append(features, "preload")
+	}
+
+	if cfg.registry != nil {
+		features = append(features, "debug")
+	}
+
+	if cfg.devFiles != nil {
+		features = append(features, "dev")
+	}
+
+	return features
+}
+
+func (cfg *config) settings() []string {
+	maxDuration := "none"
+	if cfg.maxDuration > 0 {
+		maxDuration = cfg.maxDuration.String()
+	}
+
+	maxRequests := "unlimited"
+	if cfg.maxRequests > 0 {
+		maxRequests = fmt.Sprint(cfg.maxRequests)
+	}
+
+	quota := "none"
+	if cfg.quota != nil {
+		quota = fmt.Sprintf("%d bytes", cfg.quota.limit)
+	}
+
+	idempotencyTTL := "disabled"
+	if cfg.idempotency != nil {
+		idempotencyTTL = cfg.idempotency.ttl.String()
+	}
+
+	index := "none"
+	if len(cfg.indexFiles) != 0 {
+		index = strings.Join(cfg.indexFiles, ", ")
+	}
+
+	cacheControl := cfg.cacheControl.String()
+	if cacheControl == "" {
+		cacheControl = "none"
+	}
+
+	return []string{
+		"timeout: " + cfg.timeout.String(),
+		"idle timeout: " + cfg.idleTimeout.String(),
+		"max request duration: " + maxDuration,
+		"max request line: " + fmt.Sprintf("%d bytes", cfg.maxRequestLine),
+		"max requests per connection: " + maxRequests,
+		"quota: " + quota,
+		"idempotency ttl: " + idempotencyTTL,
+		"index files: " + index,
+		"cache control: " + cacheControl,
+		"log level: " + logger.level.String(),
+	}
+}
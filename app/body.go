@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDrain is how much of an unread request body the server discards to
+// reuse the connection. Larger leftovers close the connection instead.
+const maxDrain = 64 * 1024
+
+// fixedLengthReader reads a body delimited by Content-Length from the
+// connection. Running out of input before the declared length is an error
+// wrapping errMalformedRequest rather than a silent short body.
+type fixedLengthReader struct {
+	limited *io.LimitedReader
+	length  int64
+}
+
+func newFixedLengthReader(reader io.Reader, length int64) *fixedLengthReader {
+	return &fixedLengthReader{
+		limited: &io.LimitedReader{R: reader, N: length},
+		length:  length,
+	}
+}
+
+func (r *fixedLengthReader) Read(p []byte) (int, error) {
+	n, err := r.limited.Read(p)
+	if err == io.EOF && r.limited.N > 0 {
+		return n, fmt.Errorf("%w: body ended after %d of %d bytes", errMalformedRequest, r.length-r.limited.N, r.length)
+	}
+
+	return n, err
+}
+
+// sizeLimitReader fails with errRequestTooLarge once more than remaining
+// bytes are read from a body whose size isn't known up front.
+type sizeLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	// read one byte past the limit to tell a body that ends right at it from
+	// one that goes over
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+
+	if r.remaining < 0 {
+		return n + int(r.remaining), errRequestTooLarge
+	}
+
+	return n, err
+}
+
+// continueReader answers the Expect: 100-continue of a request with a 100
+// Continue the first time its body is read. A handler that rejects the
+// request without reading the body sends its final response instead, so the
+// client never uploads a body that would only be discarded.
+type continueReader struct {
+	ctx    context.Context
+	c      *connection
+	reader io.Reader
+	sent   bool
+}
+
+func (r *continueReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+
+		if err := r.c.send(r.ctx, buildResponse(continue_, nil, "")); err != nil {
+			return 0, err
+		}
+	}
+
+	return r.reader.Read(p)
+}
+
+// awaiting reports whether the client is still waiting to be told to send the
+// body.
+func (r *continueReader) awaiting() bool {
+	return r != nil && !r.sent
+}
+
+// readContent reads the rest of the body into content for handlers that want
+// the whole body in memory.
+func (r *request) readContent() (string, error) {
+	var builder strings.Builder
+
+	if _, err := io.Copy(&builder, r.body); err != nil {
+		return "", err
+	}
+
+	r.content += builder.String()
+
+	return r.content, nil
+}
+
+// drainBody discards whatever the handler left unread of the body so the next
+// request on the connection starts in the right place. It reports false if
+// the body couldn't be drained, in which case the connection must be closed.
+func (r *request) drainBody() bool {
+	n, err := io.Copy(io.Discard, io.LimitReader(r.body, maxDrain+1))
+	return err == nil && n <= maxDrain
+}
+
+// previewLength is how much of each request body -log-body records.
+const previewLength = 100
+
+// previewReader keeps a copy of the first previewLength bytes read through
+// it, so the access log can show what a body started with without holding on
+// to large uploads.
+type previewReader struct {
+	reader  io.Reader
+	preview []byte
+}
+
+func (r *previewReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+
+	if room := previewLength - len(r.preview); room > 0 {
+		if n < room {
+			room = n
+		}
+
+		r.preview = append(r.preview, p[:room]...)
+	}
+
+	return n, err
+}
+
+// escapePreview makes a body preview safe to log on a single line, escaping
+// every byte outside printable ASCII along with quotes and backslashes.
+func escapePreview(preview []byte) string {
+	var builder strings.Builder
+
+	for _, b := range preview {
+		if b < 0x20 || b > 0x7e || b == '"' || b == '\\' {
+			fmt.Fprintf(&builder, "\\x%02x", b)
+			continue
+		}
+
+		builder.WriteByte(b)
+	}
+
+	return builder.String()
+}
+
+// mergeContentLength combines the value of a Content-Length header with the
+// previous one, "" for the first. A value may also list the length several
+// times separated by commas. Repeats of the same length collapse into one,
+// differing lengths fail with errMalformedRequest.
+func mergeContentLength(previous string, value string) (string, error) {
+	merged := previous
+
+	for _, length := range strings.Split(value, ",") {
+		length = strings.TrimSpace(length)
+
+		if merged != "" && length != merged {
+			return "", fmt.Errorf("%w: conflicting Content-Length values %q and %q", errMalformedRequest, merged, length)
+		}
+
+		merged = length
+	}
+
+	return merged, nil
+}
+
+// bodyError is the httpError for a handler that failed with err while storing
+// the request body: 400 when the body is malformed or ended early, 413 when
+// it's over -max-request-size, and a 500 with message otherwise.
+func bodyError(err error, message string) error {
+	switch {
+	case errors.Is(err, errMalformedRequest):
+		return &httpError{status: bad_request, message: "malformed request body", err: err}
+	case errors.Is(err, errRequestTooLarge):
+		return &httpError{status: payload_too_large, message: "request exceeds the maximum request size", err: err}
+	}
+
+	return &httpError{status: internal_server_error, message: message, err: err}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBodyShorterThanContentLength(t *testing.T) {
+	cfg := testConfig(t)
+
+	// the client closes its side after half the declared body
+	output, _ := exchange(t, cfg, "POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello")
+
+	response := parseResponses(t, output, "POST")[0]
+
+	if response.status != 400 {
+		t.Errorf("got status %d, want 400", response.status)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.filesDir, "a.txt")); err == nil {
+		t.Errorf("truncated upload was saved")
+	}
+}
+
+func TestBodyLongerThanContentLength(t *testing.T) {
+	cfg := testConfig(t)
+
+	// the bytes past the declared body are the next request
+	output, _ := exchange(t, cfg, "POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc"+
+		"GET /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	responses := parseResponses(t, output, "POST", "GET")
+
+	if responses[0].status != 201 {
+		t.Errorf("got status %d for the upload, want 201", responses[0].status)
+	}
+
+	if responses[1].status != 200 || responses[1].body != "abc" {
+		t.Errorf("got %d %q for the pipelined GET, want 200 \"abc\"", responses[1].status, responses[1].body)
+	}
+}
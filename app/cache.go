@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// cacheControlRules maps file extensions to the Cache-Control header sent for
+// files with that extension. It can be given as a flag more than once, each
+// value holding one or more rules separated by ";". A rule is either
+// ".ext=value" for a single extension or a bare value used as the default
+// for files without an extension rule.
+type cacheControlRules struct {
+	byExtension map[string]string
+	fallback    string
+}
+
+func (r *cacheControlRules) String() string {
+	if r == nil {
+		return ""
+	}
+
+	var rules []string
+	for ext, value := range r.byExtension {
+		rules = append(rules, ext+"="+value)
+	}
+
+	if r.fallback != "" {
+		rules = append(rules, r.fallback)
+	}
+
+	return strings.Join(rules, ";")
+}
+
+func (r *cacheControlRules) Set(value string) error {
+	for _, rule := range strings.Split(value, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(rule, ".") {
+			r.fallback = rule
+			continue
+		}
+
+		ext, header, found := strings.Cut(rule, "=")
+		if !found || len(ext) < 2 || strings.TrimSpace(header) == "" {
+			return fmt.Errorf("invalid cache control rule %q, expected .ext=value", rule)
+		}
+
+		if r.byExtension == nil {
+			r.byExtension = make(map[string]string)
+		}
+
+		r.byExtension[strings.ToLower(ext)] = strings.TrimSpace(header)
+	}
+
+	return nil
+}
+
+// lookup returns the Cache-Control value for fileName, or false when no rule
+// matches and there is no default.
+func (r *cacheControlRules) lookup(fileName string) (string, bool) {
+	if value, ok := r.byExtension[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return value, true
+	}
+
+	return r.fallback, r.fallback != ""
+}
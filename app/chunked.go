@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// chunkedWriter sends everything written to it to the client using the
+// chunked transfer coding, one chunk per Write. Close sends the terminating
+// zero length chunk and must be called once the body is complete.
+type chunkedWriter struct {
+	ctx context.Context
+	c   *connection
+}
+
+func newChunkedWriter(ctx context.Context, c *connection) *chunkedWriter {
+	return &chunkedWriter{ctx: ctx, c: c}
+}
+
+func (w *chunkedWriter) Write(p []byte) (int, error) {
+	// a zero length chunk would terminate the body early
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	chunk := make([]byte, 0, len(p)+32)
+	chunk = append(chunk, fmt.Sprintf("%x\r\n", len(p))...)
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+
+	if err := w.c.send(w.ctx, chunk); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *chunkedWriter) Close() error {
+	return w.closeWithTrailers(nil)
+}
+
+// acceptsTrailers reports whether the value of a TE header lists trailers,
+// meaning the client accepts trailer fields in a chunked response.
+func acceptsTrailers(te string) bool {
+	for _, coding := range strings.Split(te, ",") {
+		name, _, _ := strings.Cut(coding, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "trailers") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// closeWithTrailers terminates the body like Close, followed by trailer fields
+// holding metadata only known once the body has been sent. Trailers should be
+// announced with a Trailer header in the response.
+func (w *chunkedWriter) closeWithTrailers(trailers []string) error {
+	var builder strings.Builder
+
+	builder.WriteString("0\r\n")
+	for _, trailer := range trailers {
+		builder.WriteString(trailer + "\r\n")
+	}
+	builder.WriteString("\r\n")
+
+	return w.c.send(w.ctx, []byte(builder.String()))
+}
+
+// maxChunkLine bounds the chunk size line, including any chunk extensions.
+const maxChunkLine = 4096
+
+// errChunkedTruncated is returned when the connection ends before the last
+// chunk of a body. The client is done sending, so the body is malformed
+// rather than the request aborted.
+var errChunkedTruncated = fmt.Errorf("%w: body ended before its last chunk", errMalformedRequest)
+
+// chunkedReader decodes a request body sent with the chunked transfer coding,
+// reading it from the connection as the consumer asks for it. Trailer fields
+// after the last chunk are read and discarded. Malformed bodies fail with an
+// error wrapping errMalformedRequest.
+type chunkedReader struct {
+	reader *bufio.Reader
+
+	// remaining is the number of bytes left in the current chunk
+	remaining int64
+	done      bool
+}
+
+func newChunkedReader(reader *bufio.Reader) *chunkedReader {
+	return &chunkedReader{reader: reader}
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	if r.remaining == 0 {
+		size, err := r.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+
+		if size == 0 {
+			if err := r.skipTrailers(); err != nil {
+				return 0, err
+			}
+
+			r.done = true
+			return 0, io.EOF
+		}
+
+		r.remaining = size
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+
+	if err == io.EOF {
+		return n, errChunkedTruncated
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if r.remaining == 0 {
+		if err := r.expectCRLF(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (r *chunkedReader) readLine() (string, error) {
+	var line []byte
+
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > maxChunkLine {
+			return "", fmt.Errorf("%w: chunk line too long", errMalformedRequest)
+		}
+
+		if err == nil {
+			break
+		}
+
+		if err == io.EOF {
+			return "", errChunkedTruncated
+		}
+
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
+	}
+
+	return strings.TrimSuffix(string(line), "\r\n"), nil
+}
+
+func (r *chunkedReader) nextChunk() (int64, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+
+	// chunk extensions aren't used for anything, so drop them
+	sizeField, _, _ := strings.Cut(line, ";")
+
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", errMalformedRequest, sizeField)
+	}
+
+	return size, nil
+}
+
+func (r *chunkedReader) expectCRLF() error {
+	line, err := r.readLine()
+	if err != nil {
+		return err
+	}
+
+	if line != "" {
+		return fmt.Errorf("%w: chunk data longer than its size", errMalformedRequest)
+	}
+
+	return nil
+}
+
+func (r *chunkedReader) skipTrailers() error {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return err
+		}
+
+		if line == "" {
+			return nil
+		}
+	}
+}
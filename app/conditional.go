@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileETag derives a strong entity tag for a file from its size and
+// modification time, so it changes whenever the file is rewritten without
+// having to hash the content.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// lastModified formats the modification time of a file as an HTTP-date.
+func lastModified(info os.FileInfo) string {
+	return info.ModTime().UTC().Format(http.TimeFormat)
+}
+
+// etagMatches reports whether etag is one of the entity tags listed in the
+// value of an If-Match header. Weak tags never match since If-Match uses the
+// strong comparison.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// preconditionsMet evaluates If-Match, If-Unmodified-Since and If-None-Match
+// against the current state of a file, info being nil when the file doesn't
+// exist. A write must answer 412 Precondition Failed when it returns false.
+// "If-Match: *" only lets a write through when the file exists and
+// "If-None-Match: *" only when it doesn't, for update-only and create-only
+// writes.
+func preconditionsMet(request *request, info os.FileInfo) bool {
+	if ifMatch, ok := request.headers["If-Match"]; ok {
+		if info == nil || !etagMatches(ifMatch, fileETag(info)) {
+			return false
+		}
+	} else if !unmodifiedSince(request, info) {
+		return false
+	}
+
+	ifNoneMatch, ok := request.headers["If-None-Match"]
+
+	return !ok || info == nil || !weakMatches(ifNoneMatch, fileETag(info))
+}
+
+// unmodifiedSince evaluates If-Unmodified-Since, which is ignored when the
+// file doesn't exist and when its value isn't a valid date.
+func unmodifiedSince(request *request, info os.FileInfo) bool {
+	ifUnmodifiedSince, ok := request.headers["If-Unmodified-Since"]
+	if !ok || info == nil {
+		return true
+	}
+
+	since, err := http.ParseTime(ifUnmodifiedSince)
+	if err != nil {
+		return true
+	}
+
+	// HTTP-dates only have second precision
+	return !info.ModTime().Truncate(time.Second).After(since)
+}
+
+// weakMatches reports whether etag is one of the entity tags listed in the
+// value of an If-None-Match header, using the weak comparison.
+func weakMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifRangeMatches reports whether the validator in an If-Range header still
+// describes the file, in which case the requested range is served. Otherwise
+// the whole file is sent. The validator is an entity tag when it's quoted or
+// has the weak prefix and an HTTP-date otherwise. Weak tags and unparsable
+// dates never match.
+func ifRangeMatches(header string, info os.FileInfo) bool {
+	header = strings.TrimSpace(header)
+
+	if strings.HasPrefix(header, `"`) || strings.HasPrefix(header, "W/") {
+		return header == fileETag(info)
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+
+	return info.ModTime().Truncate(time.Second).Equal(date)
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since for a GET or HEAD
+// of a file, reporting whether the client's copy is current and a 304 Not
+// Modified can be sent instead of the file. If-None-Match uses the weak
+// comparison and takes precedence over If-Modified-Since.
+func notModified(request *request, info os.FileInfo) bool {
+	if ifNoneMatch, ok := request.headers["If-None-Match"]; ok {
+		return weakMatches(ifNoneMatch, fileETag(info))
+	}
+
+	ifModifiedSince, ok := request.headers["If-Modified-Since"]
+	if !ok {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	return !info.ModTime().Truncate(time.Second).After(since)
+}
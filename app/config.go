@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile applies the settings in the file at path to the flags in fs.
+//
+// Keys are flag names without the leading dash. The file is either a JSON
+// object or a list of key=value lines where blank lines and lines starting
+// with # are ignored. Precedence, from highest to lowest, is:
+//
+//  1. flags given on the command line
+//  2. values from the config file
+//  3. flag defaults
+//
+// Unknown keys are an error so that typos don't go unnoticed.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]string
+
+	trimmed := bytes.TrimSpace(data)
+	if filepath.Ext(path) == ".json" || bytes.HasPrefix(trimmed, []byte("{")) {
+		values, err = parseJSONConfig(trimmed)
+	} else {
+		values, err = parseKeyValueConfig(data)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for key, value := range values {
+		if key == "config" || fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown key %q in config file %s", key, path)
+		}
+
+		if explicit[key] {
+			continue
+		}
+
+		if err := fs.Set(key, value); err != nil {
+			return fmt.Errorf("invalid value %q for key %q in config file %s: %w", value, key, path, err)
+		}
+	}
+
+	return nil
+}
+
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case float64:
+			values[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("unsupported value for key %q, expected a string, number or boolean", key)
+		}
+	}
+
+	return values, nil
+}
+
+func parseKeyValueConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key=value", lineNumber)
+		}
+
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
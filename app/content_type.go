@@ -0,0 +1,61 @@
+package main
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// textualTypes are the non text/* media types that hold text and so get a
+// charset like text/* types do.
+var textualTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+}
+
+// fileContentType picks the Content-Type for a served file from its extension.
+// Text types get "; charset=utf-8" when content, the file or just its start
+// for streamed files, is valid UTF-8. Anything not recognised is served as
+// application/octet-stream.
+func fileContentType(fileName string, content []byte) string {
+	mediaType := fileMediaType(fileName)
+
+	if (strings.HasPrefix(mediaType, "text/") || textualTypes[mediaType]) && utf8.Valid(content) {
+		return mediaType + "; charset=utf-8"
+	}
+
+	return mediaType
+}
+
+// fileMediaType is the media type of fileName going by its extension, without
+// any parameters.
+func fileMediaType(fileName string) string {
+	mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(fileName)))
+	if err != nil || mediaType == "" {
+		return "application/octet-stream"
+	}
+
+	return mediaType
+}
+
+// sniffLength is how much of a streamed file is looked at to pick its
+// Content-Type.
+const sniffLength = 512
+
+// trimPartialRune drops a UTF-8 sequence cut off at the end of prefix, the
+// start of a longer file, so that it isn't mistaken for invalid UTF-8.
+func trimPartialRune(prefix []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(prefix); i++ {
+		if utf8.RuneStart(prefix[len(prefix)-i]) {
+			if !utf8.FullRune(prefix[len(prefix)-i:]) {
+				return prefix[:len(prefix)-i]
+			}
+
+			break
+		}
+	}
+
+	return prefix
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// contextKey is the type of the keys for the request scoped values carried by
+// the context handed to handlers, so they can't collide with other packages.
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	startTimeKey
+	userKey
+	methodKey
+	pathKey
+)
+
+// withRequest returns a copy of ctx carrying the ID and start time of the
+// request it's used for.
+func withRequest(ctx context.Context, id string, start time.Time) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return context.WithValue(ctx, startTimeKey, start)
+}
+
+// withUser returns a copy of ctx carrying the name of the authenticated user.
+func withUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// requestID returns the ID of the request ctx belongs to.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// requestStart returns when the request ctx belongs to started.
+func requestStart(ctx context.Context) time.Time {
+	start, _ := ctx.Value(startTimeKey).(time.Time)
+	return start
+}
+
+// authenticatedUser returns the user that authenticated the request ctx
+// belongs to, if authentication is enabled.
+func authenticatedUser(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userKey).(string)
+	return user, ok
+}
+
+// withTarget returns a copy of ctx carrying the method and path from the
+// request line.
+func withTarget(ctx context.Context, method string, path string) context.Context {
+	ctx = context.WithValue(ctx, methodKey, method)
+	return context.WithValue(ctx, pathKey, path)
+}
+
+// requestTarget returns the method and path of the request ctx belongs to,
+// both empty when the request line couldn't be read.
+func requestTarget(ctx context.Context) (string, string) {
+	method, _ := ctx.Value(methodKey).(string)
+	path, _ := ctx.Value(pathKey).(string)
+	return method, path
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// Bounds and default of -stream-chunk-size, the size of the chunks ctxCopy
+// moves between checks of its context.
+const (
+	minStreamChunkSize     = 4 * 1024
+	maxStreamChunkSize     = 1024 * 1024
+	defaultStreamChunkSize = 32 * 1024
+)
+
+// ctxCopy copies src to dst like io.Copy in chunks of chunkSize bytes, but
+// checks ctx between chunks and stops with its error once it's done, so a
+// long copy ends as soon as the request times out rather than at the next
+// failing write.
+func ctxCopy(ctx context.Context, dst io.Writer, src io.Reader, chunkSize int) (int64, error) {
+	buf := make([]byte, chunkSize)
+
+	var written int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return written, ctx.Err()
+		default:
+		}
+
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			w, err := dst.Write(buf[:n])
+			written += int64(w)
+
+			if err != nil {
+				return written, err
+			}
+
+			if w != n {
+				return written, io.ErrShortWrite
+			}
+		}
+
+		if readErr == io.EOF {
+			return written, nil
+		}
+
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// discardConn is a scriptedConn that throws away what the server writes, so
+// the benchmark measures the copy and not a growing buffer.
+type discardConn struct {
+	scriptedConn
+	written int64
+}
+
+func (c *discardConn) Write(p []byte) (int, error) {
+	c.written += int64(len(p))
+	return len(p), nil
+}
+
+func BenchmarkStreamFile(b *testing.B) {
+	const size = 16 << 20
+
+	cfg := testConfig(b)
+	writeFile(b, cfg, "large.bin", strings.Repeat("0123456789abcdef", size/16))
+
+	const raw = "GET /files/large.bin HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"
+
+	for _, chunkSize := range []int{minStreamChunkSize, 16 * 1024, defaultStreamChunkSize, 128 * 1024, maxStreamChunkSize} {
+		b.Run(fmt.Sprintf("%dKB", chunkSize/1024), func(b *testing.B) {
+			streamed := *cfg
+			streamed.chunkSize = chunkSize
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				conn := &discardConn{scriptedConn: scriptedConn{input: strings.NewReader(raw)}}
+
+				c, _ := newConnection(conn, &streamed)
+				if err := c.handle(); err != nil {
+					b.Fatalf("handle failed: %v", err)
+				}
+
+				if conn.written < size {
+					b.Fatalf("only %d bytes written", conn.written)
+				}
+			}
+		})
+	}
+}
+
+func TestCtxCopyStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var dst bytes.Buffer
+	if _, err := ctxCopy(ctx, &dst, strings.NewReader("content"), minStreamChunkSize); err == nil {
+		t.Fatalf("copy under a cancelled context succeeded")
+	}
+}
+
+func TestCtxCopyUsesChunkSize(t *testing.T) {
+	src := &countingReader{reader: strings.NewReader(strings.Repeat("x", 10000))}
+
+	var dst bytes.Buffer
+	written, err := ctxCopy(context.Background(), &dst, src, minStreamChunkSize)
+	if err != nil || written != 10000 {
+		t.Fatalf("got %d bytes, %v, want 10000", written, err)
+	}
+
+	// 4096 + 4096 + 1808 and the final EOF
+	if src.reads != 4 {
+		t.Errorf("got %d reads, want 4", src.reads)
+	}
+}
+
+type countingReader struct {
+	reader io.Reader
+	reads  int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.reads++
+	return r.reader.Read(p)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// sendSlowly writes raw to conn in parts, pausing between them.
+func sendSlowly(conn net.Conn, pause time.Duration, parts ...string) {
+	for _, part := range parts {
+		time.Sleep(pause)
+		if _, err := io.WriteString(conn, part); err != nil {
+			return
+		}
+	}
+}
+
+func TestKeepAliveRequestsGetTheirOwnDeadline(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.timeout = 500 * time.Millisecond
+
+	client, reader := dial(t, cfg)
+
+	// each request takes most of the timeout to arrive, together they take
+	// far longer than it
+	for _, word := range []string{"first", "second", "third"} {
+		go sendSlowly(client, 70*time.Millisecond, "GET /echo/"+word, " HTTP/1.1\r\n", "Host: localhost\r\n", "\r\n")
+
+		response := readResponse(t, reader, "GET")
+		if response.status != 200 || response.body != word {
+			t.Fatalf("got %d %q, want 200 %q", response.status, response.body, word)
+		}
+
+		// idle between requests, within the idle timeout
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestSlowRequestHeadTimesOut(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.timeout = 200 * time.Millisecond
+
+	client, reader := dial(t, cfg)
+
+	// the head takes twice the timeout to arrive
+	go sendSlowly(client, 100*time.Millisecond, "GET /echo/slow HTTP/1.1\r\n", "Host: localhost\r\n", "X-A: 1\r\n", "X-B: 2\r\n", "\r\n")
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	response, err := http.ReadResponse(reader, nil)
+	if err == nil && response.StatusCode == 200 {
+		t.Errorf("request slower than the timeout was served")
+	}
+}
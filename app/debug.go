@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+
+	return n, err
+}
+
+// connRegistry keeps track of the open connections for /debug/connections,
+// it's only kept with -debug.
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[*connection]bool
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{conns: make(map[*connection]bool)}
+}
+
+func (r *connRegistry) add(c *connection) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conns[c] = true
+}
+
+func (r *connRegistry) remove(c *connection) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, c)
+}
+
+type connSummary struct {
+	RemoteAddr   string  `json:"remote_addr"`
+	Request      string  `json:"request,omitempty"`
+	BytesRead    int64   `json:"bytes_read"`
+	BytesWritten int64   `json:"bytes_written"`
+	AgeSeconds   float64 `json:"age_seconds"`
+}
+
+// render encodes the open connections as JSON, oldest first. Request is the
+// request line of the request a connection is serving, if any.
+func (r *connRegistry) render() (string, error) {
+	r.mu.Lock()
+	conns := make([]*connection, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].accepted.Before(conns[j].accepted) })
+
+	summaries := make([]connSummary, 0, len(conns))
+	for _, c := range conns {
+		current, _ := c.current.Load().(string)
+
+		summaries = append(summaries, connSummary{
+			RemoteAddr:   c.conn.RemoteAddr().String(),
+			Request:      current,
+			BytesRead:    c.traffic.read.Load(),
+			BytesWritten: c.traffic.written.Load(),
+			AgeSeconds:   time.Since(c.accepted).Seconds(),
+		})
+	}
+
+	encoded, err := json.Marshal(summaries)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode connections: %w", err)
+	}
+
+	return string(encoded), nil
+}
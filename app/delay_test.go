@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	start := time.Now()
+
+	response := roundTrip(t, testConfig(t), "GET /delay/0.2 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 200 {
+		t.Fatalf("got status %d, want 200", response.status)
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("answered after %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestDelayInvalid(t *testing.T) {
+	for _, value := range []string{"soon", "-1", "NaN", ""} {
+		response := roundTrip(t, testConfig(t), "GET /delay/"+value+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		if response.status != 400 {
+			t.Errorf("got status %d for /delay/%s, want 400", response.status, value)
+		}
+	}
+}
+
+func TestDelayLongerThanTimeout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.timeout = 200 * time.Millisecond
+
+	start := time.Now()
+
+	response := roundTrip(t, cfg, "GET /delay/1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 400 {
+		t.Errorf("got status %d, want 400", response.status)
+	}
+
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("rejected after %v, want right away", elapsed)
+	}
+}
+
+func TestDelayUnderRouteTimeout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.timeout = 100 * time.Millisecond
+	if err := cfg.routeTimeouts.Set("/delay=2s"); err != nil {
+		t.Fatalf("failed to set route timeout: %v", err)
+	}
+
+	response := roundTrip(t, cfg, "GET /delay/0.3 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 200 {
+		t.Errorf("got status %d under a longer route timeout, want 200", response.status)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDeleteWithBodyKeepsConnectionAligned(t *testing.T) {
+	cfg := testConfig(t)
+	fileName := writeFile(t, cfg, "gone.txt", "bye")
+	writeFile(t, cfg, "kept.txt", "still here")
+
+	// a body that looked like a request would be answered if it weren't
+	// drained
+	body := "GET /echo/smuggled HTTP/1.1\r\n\r\n"
+
+	output, err := exchange(t, cfg, "DELETE /files/gone.txt HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\n"+
+		"Content-Length: 31\r\n\r\n"+body+
+		"GET /files/kept.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if err != nil {
+		t.Fatalf("handle failed: %v", err)
+	}
+
+	responses := parseResponses(t, output, "DELETE", "GET")
+
+	if responses[0].status != 204 {
+		t.Errorf("got status %d for DELETE, want 204", responses[0].status)
+	}
+
+	if responses[1].status != 200 || responses[1].body != "still here" {
+		t.Errorf("got %d %q for GET, want 200 \"still here\"", responses[1].status, responses[1].body)
+	}
+
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("deleted file still there: %v", err)
+	}
+}
+
+func TestDeleteWithChunkedBody(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "gone.txt", "bye")
+
+	output, _ := exchange(t, cfg, "DELETE /files/gone.txt HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"4\r\nnote\r\n0\r\n\r\n"+
+		"GET /echo/after HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	responses := parseResponses(t, output, "DELETE", "GET")
+
+	if responses[0].status != 204 || responses[1].body != "after" {
+		t.Errorf("got %d then %q, want 204 then \"after\"", responses[0].status, responses[1].body)
+	}
+}
+
+func TestDeleteMissingFile(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "DELETE /files/missing.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 404 {
+		t.Errorf("got status %d, want 404", response.status)
+	}
+}
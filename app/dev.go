@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// servedFiles remembers the modification time each file had when it was last
+// served, so dev mode can point out files that changed on disk in between.
+type servedFiles struct {
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+}
+
+func newServedFiles() *servedFiles {
+	return &servedFiles{modTimes: make(map[string]time.Time)}
+}
+
+// record stores modTime for path and returns the modification time seen the
+// previous time path was served, if it was.
+func (s *servedFiles) record(path string, modTime time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.modTimes[path]
+	s.modTimes[path] = modTime
+
+	return previous, ok
+}
+
+// logServedFile prints the details of a file served in dev mode.
+func (c *connection) logServedFile(path string, info os.FileInfo) {
+	modTime := info.ModTime()
+
+	logger.infof("dev: served %s (%d bytes, modified %s)", path, info.Size(), modTime.Format(time.RFC3339Nano))
+
+	if previous, ok := c.devFiles.record(path, modTime); ok && !previous.Equal(modTime) {
+		logger.infof("dev: %s changed since last served (was modified %s)", path, previous.Format(time.RFC3339Nano))
+	}
+}
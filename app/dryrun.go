@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dryRun reports whether request asks with X-Dry-Run: true for an upload to
+// be validated without being stored.
+func dryRun(request *request) bool {
+	return strings.EqualFold(strings.TrimSpace(request.headers["X-Dry-Run"]), "true")
+}
+
+// uploadConflict explains why nothing can be stored at fileName, because its
+// directory doesn't exist or it is a directory itself. It's "" when an upload
+// can go ahead.
+func uploadConflict(fileName string, existing fs.FileInfo) string {
+	if existing != nil && existing.IsDir() {
+		return "path is a directory"
+	}
+
+	if info, err := os.Stat(filepath.Dir(fileName)); err != nil || !info.IsDir() {
+		return "parent directory does not exist"
+	}
+
+	return ""
+}
+
+// handleDryRunUpload answers a POST or PUT of fileName sent with X-Dry-Run
+// with the status the upload would get, running the checks made before
+// anything is written but leaving the body unread and the disk untouched.
+// The size of a chunked body isn't known without reading it, so it's only
+// checked against the quota when there's a Content-Length.
+func (c *connection) handleDryRunUpload(ctx context.Context, request *request, fileName string, existing fs.FileInfo) error {
+	method := strings.Split(request.protocol, " ")[0]
+
+	if method == "PUT" && !preconditionsMet(request, existing) {
+		return newHTTPError(precondition_failed, "dry run: file was modified")
+	}
+
+	if reason := uploadConflict(fileName, existing); reason != "" {
+		return newHTTPError(conflict, "dry run: "+reason)
+	}
+
+	var existingSize int64
+	if existing != nil {
+		existingSize = existing.Size()
+	}
+
+	size := request.contentLength
+	if contentRange, ok := request.headers["Content-Range"]; ok {
+		_, total, err := parseContentRange(contentRange)
+		if err != nil {
+			return newHTTPError(bad_request, "dry run: invalid Content-Range")
+		}
+
+		size = total
+	}
+
+	if c.quota != nil && size >= 0 && !c.quota.fits(size-existingSize) {
+		return newHTTPError(insufficient_storage, "dry run: upload exceeds the storage quota")
+	}
+
+	responseType := created
+	if method == "PUT" && existing != nil {
+		responseType = ok
+	}
+
+	message := fmt.Sprintf("dry run: %s would be stored, nothing was written", filepath.Base(fileName))
+	headers := []string{"Content-Type: text/plain"}
+	if err := c.send(ctx, buildResponse(responseType, &headers, message)); err != nil {
+		return fmt.Errorf("failed to send dry run response for %s request: %w", method, err)
+	}
+
+	return nil
+}
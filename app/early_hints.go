@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// earlyHints holds the Link header values sent in a 103 Early Hints response
+// ahead of HTML files, so clients can start preloading what the page needs
+// while the file is read. It's given as a flag once per Link value.
+type earlyHints []string
+
+func (h *earlyHints) String() string {
+	if h == nil {
+		return ""
+	}
+
+	return strings.Join(*h, ", ")
+}
+
+func (h *earlyHints) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "<") {
+		return fmt.Errorf("expected a Link header value like </style.css>; rel=preload; as=style, got %q", value)
+	}
+
+	*h = append(*h, value)
+
+	return nil
+}
+
+// sendEarlyHints sends the configured hints before the response for the HTML
+// file fileName. HTTP/1.0 clients don't know about interim responses, so they
+// only get the final one.
+func (c *connection) sendEarlyHints(ctx context.Context, request *request, fileName string) error {
+	if len(c.earlyHints) == 0 || !strings.HasSuffix(request.protocol, "HTTP/1.1") {
+		return nil
+	}
+
+	if !strings.HasPrefix(mime.TypeByExtension(filepath.Ext(fileName)), "text/html") {
+		return nil
+	}
+
+	headers := make([]string, 0, len(c.earlyHints))
+	for _, link := range c.earlyHints {
+		headers = append(headers, "Link: "+link)
+	}
+
+	if err := c.send(ctx, buildResponse(early_hints, &headers, "")); err != nil {
+		return fmt.Errorf("failed to send EARLY HINTS response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmptyFile(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "empty.txt", "")
+
+	// a stray byte after the head would be read as the start of the next
+	// response
+	output, _ := exchange(t, cfg, "GET /files/empty.txt HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	responses := parseResponses(t, output, "GET", "GET")
+
+	if responses[0].status != 200 || responses[0].header.Get("Content-Length") != "0" || responses[0].body != "" {
+		t.Errorf("got %d with Content-Length %q and body %q, want 200 with an empty body", responses[0].status, responses[0].header.Get("Content-Length"), responses[0].body)
+	}
+
+	if responses[1].body != "next" {
+		t.Errorf("got %q after the empty file, want \"next\"", responses[1].body)
+	}
+}
+
+func TestEmptyFileAs204(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.emptyAs204 = true
+	writeFile(t, cfg, "empty.txt", "")
+
+	output, _ := exchange(t, cfg, "GET /files/empty.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if head, _, _ := strings.Cut(output, "\r\n\r\n"); strings.Contains(head, "Content-Length") {
+		t.Errorf("204 sent with Content-Length:\n%s", head)
+	}
+
+	if response := parseResponses(t, output, "GET")[0]; response.status != 204 {
+		t.Errorf("got status %d with -empty-as-204, want 204", response.status)
+	}
+}
+
+func TestEmptyFileRange(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "empty.txt", "")
+
+	// no byte range of an empty file is satisfiable
+	response := roundTrip(t, cfg, "GET /files/empty.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-\r\n\r\n")
+
+	if response.status != 416 || response.header.Get("Content-Range") != "bytes */0" {
+		t.Errorf("got %d with Content-Range %q, want 416 bytes */0", response.status, response.header.Get("Content-Range"))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestRequestCutOffByEOF(t *testing.T) {
+	tests := map[string]string{
+		"mid request line":  "GET /echo/abc HTTP/1.1",
+		"mid headers":       "GET /echo/abc HTTP/1.1\r\nHost: localhost\r\nUser-Agent: cu",
+		"before blank line": "GET /echo/abc HTTP/1.1\r\nHost: localhost\r\n",
+		"mid body":          "POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhalf",
+		"mid chunk":         "POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\na\r\nhalf",
+	}
+
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig(t)
+
+			output, _ := exchange(t, cfg, raw)
+
+			// a lone response shows the connection ended right after it
+			response := parseResponses(t, output, "GET")[0]
+
+			if response.status != 400 {
+				t.Errorf("got status %d, want 400", response.status)
+			}
+
+			if !response.close {
+				t.Errorf("400 without Connection: close")
+			}
+		})
+	}
+}
+
+func TestTruncatedUploadLeavesNoFile(t *testing.T) {
+	cfg := testConfig(t)
+
+	exchange(t, cfg, "PUT /files/a.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhalf")
+
+	response := roundTrip(t, cfg, "GET /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 404 {
+		t.Errorf("got status %d for the truncated upload, want 404", response.status)
+	}
+}
+
+func TestEOFBetweenRequestsIsClean(t *testing.T) {
+	output, err := exchange(t, testConfig(t), "GET /echo/abc HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if err != nil {
+		t.Fatalf("handle failed after a complete request: %v", err)
+	}
+
+	if response := parseResponses(t, output, "GET")[0]; response.status != 200 {
+		t.Errorf("got status %d, want 200", response.status)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errorBody is the body of error responses in the json error format.
+type errorBody struct {
+	Error string `json:"error"`
+	Path  string `json:"path,omitempty"`
+}
+
+// errorResponse builds an error response in the configured error format. In
+// the text format message becomes a plain text body, or the response has none
+// when message is empty. In the json format the body always describes the
+// error, falling back to the reason phrase of the status line without a
+// message. Responses to HEAD requests keep the headers but drop the body.
+func (c *connection) errorResponse(ctx context.Context, protocol string, headers *[]string, message string) []byte {
+	var extra []string
+	if headers != nil {
+		extra = append(extra, *headers...)
+	}
+
+	method, path := requestTarget(ctx)
+
+	var body string
+
+	switch {
+	case c.errorFormat == "json":
+		if message == "" {
+			if _, reason, found := strings.Cut(strings.TrimPrefix(protocol, "HTTP/1.1 "), " "); found {
+				message = strings.ToLower(reason)
+			}
+		}
+
+		encoded, err := json.Marshal(errorBody{Error: message, Path: path})
+		if err != nil {
+			encoded = []byte(fmt.Sprintf(`{"error":%q}`, message))
+		}
+
+		body = string(encoded)
+		setHeader(&extra, "Content-Type", "application/json")
+		setHeader(&extra, "Content-Length", strconv.Itoa(len(body)))
+	case message != "":
+		body = message
+		setHeader(&extra, "Content-Type", "text/plain")
+		setHeader(&extra, "Content-Length", strconv.Itoa(len(body)))
+	}
+
+	if method == "HEAD" {
+		body = ""
+	}
+
+	return buildResponse(protocol, &extra, body)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// builtinFavicon is the -favicon value that serves defaultFavicon.
+const builtinFavicon = "builtin"
+
+// defaultFavicon is a 16x16 icon of a single blue square, a PNG inside an ICO
+// container.
+var defaultFavicon = []byte{
+	0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x10, 0x10, 0x00, 0x00, 0x01, 0x00,
+	0x20, 0x00, 0x52, 0x00, 0x00, 0x00, 0x16, 0x00, 0x00, 0x00, 0x89, 0x50,
+	0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48,
+	0x44, 0x52, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x10, 0x08, 0x06,
+	0x00, 0x00, 0x00, 0x1f, 0xf3, 0xff, 0x61, 0x00, 0x00, 0x00, 0x19, 0x49,
+	0x44, 0x41, 0x54, 0x78, 0xda, 0x63, 0xd0, 0xce, 0xd9, 0xf0, 0x9f, 0x12,
+	0xcc, 0x30, 0x6a, 0xc0, 0xa8, 0x01, 0xa3, 0x06, 0x0c, 0x17, 0x03, 0x00,
+	0x19, 0x43, 0x46, 0x1f, 0xb9, 0x59, 0xe9, 0xf9, 0x00, 0x00, 0x00, 0x00,
+	0x49, 0x45, 0x4e, 0x44, 0xae, 0x42, 0x60, 0x82,
+}
+
+// loadFavicon returns the icon served for /favicon.ico with -favicon, which
+// is either the path of an .ico file or builtinFavicon.
+func loadFavicon(value string) ([]byte, error) {
+	if value == builtinFavicon {
+		return defaultFavicon, nil
+	}
+
+	icon, err := os.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read favicon: %w", err)
+	}
+
+	return icon, nil
+}
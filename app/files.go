@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var errSymlink = errors.New("path goes through a symlink")
+
+// fsPath converts the part of a request path after /files/ into a name that
+// can be opened in the files fs.FS. Names in an fs.FS are unrooted and may not
+// contain "." or ".." elements, so the path is cleaned against the root first.
+func fsPath(requestPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// indexFile looks for the first of the configured index files in the
+// directory dir, returning its name and info.
+func (c *connection) indexFile(dir string) (string, fs.FileInfo, bool) {
+	for _, index := range c.indexFiles {
+		name := path.Join(dir, index)
+
+		info, err := fs.Stat(c.files, name)
+		if err == nil && info.Mode().IsRegular() {
+			return name, info, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// parseIndexFiles splits the comma separated value of -index into file names,
+// dropping empty entries.
+func parseIndexFiles(value string) []string {
+	var names []string
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// spaFallback returns the single page app entry file to serve in place of the
+// missing file name. Only names without an extension fall back, so that a
+// missing asset like a script is still a 404 rather than a page of HTML.
+func (c *connection) spaFallback(name string) (string, fs.FileInfo, bool) {
+	if c.spaEntry == "" || path.Ext(name) != "" {
+		return "", nil, false
+	}
+
+	info, err := fs.Stat(c.files, c.spaEntry)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, false
+	}
+
+	return c.spaEntry, info, true
+}
+
+// checkSymlinks enforces the symlink policy for serving the file at name. By
+// default no element of the path may be a symlink, failing with errSymlink.
+// With -follow-symlinks they're followed, but the target still has to be
+// inside filesDir or errOutsideFilesDir is returned. Files served from a zip
+// archive or stdin are never checked.
+func (c *connection) checkSymlinks(name string) error {
+	// the entries of a zip archive are never followed as links, and the
+	// -serve-stdin file is only in memory
+	if c.zipPath != "" || name == c.stdinName {
+		return nil
+	}
+
+	if c.followSymlinks {
+		_, err := resolvePath(c.filesDir, name)
+		return err
+	}
+
+	current := c.filesDir
+	for _, element := range strings.Split(name, "/") {
+		current = filepath.Join(current, element)
+
+		info, err := os.Lstat(current)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s", errSymlink, current)
+		}
+	}
+
+	return nil
+}
+
+// streamable reports whether the file described by info, of mediaType, can
+// be sent straight from disk in response to request. Small files are sent in
+// one write with the headers, and ranges, compression and post-processors
+// need the whole file.
+func (c *connection) streamable(request *request, info fs.FileInfo, mediaType string) bool {
+	if info.Size() <= maxSingleWrite || postProcessed(mediaType) {
+		return false
+	}
+
+	if _, ok := request.headers["Range"]; ok && c.ranges {
+		return false
+	}
+
+	return !c.gzip || !acceptsGzip(request.headers["Accept-Encoding"])
+}
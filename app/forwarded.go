@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// clientAddr returns the address of the client that made request. By default
+// this is always the socket peer. When the server is configured to trust a
+// proxy in front of it, the for= address of the first Forwarded element, or
+// else the first address in X-Forwarded-For (or X-Real-IP), is used instead,
+// provided it is a well formed IP address.
+func (c *connection) clientAddr(request *request) string {
+	peer := c.conn.RemoteAddr().String()
+
+	if !c.trustProxy || request == nil {
+		return peer
+	}
+
+	if forwarded, ok := request.headers["Forwarded"]; ok {
+		if ip := forwardedFor(forwarded); ip != nil {
+			return ip.String()
+		}
+	}
+
+	if forwardedFor, ok := request.headers["X-Forwarded-For"]; ok {
+		first, _, _ := strings.Cut(forwardedFor, ",")
+		if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+			return ip.String()
+		}
+	}
+
+	if realIP, ok := request.headers["X-Real-Ip"]; ok {
+		if ip := net.ParseIP(strings.TrimSpace(realIP)); ip != nil {
+			return ip.String()
+		}
+	}
+
+	return peer
+}
+
+// forwardedFor returns the IP address in the for= parameter of the first
+// element of a Forwarded header (RFC 7239), which describes the client as
+// seen by the proxy closest to it. It's nil when the parameter is missing or
+// isn't an IP address, like the "unknown" and "_obfuscated" identifiers.
+func forwardedFor(header string) net.IP {
+	element, _ := cutUnquoted(header, ',')
+
+	for element != "" {
+		var pair string
+		pair, element = cutUnquoted(element, ';')
+
+		key, value, found := strings.Cut(pair, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "for") {
+			continue
+		}
+
+		return parseNode(unquote(strings.TrimSpace(value)))
+	}
+
+	return nil
+}
+
+// cutUnquoted slices s around the first sep that isn't inside a quoted
+// string, returning s and "" when there's none.
+func cutUnquoted(s string, sep byte) (string, string) {
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			return s[:i], s[i+1:]
+		}
+	}
+
+	return s, ""
+}
+
+// unquote removes the quotes and escapes of a quoted string, other values are
+// returned as they are.
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+
+	var builder strings.Builder
+
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && i+1 < len(value)-1 {
+			i++
+		}
+
+		builder.WriteByte(value[i])
+	}
+
+	return builder.String()
+}
+
+// parseNode parses the IP address of a Forwarded node, which may carry a
+// port, with IPv6 addresses in brackets as in "[2001:db8::1]:4711".
+func parseNode(node string) net.IP {
+	if strings.HasPrefix(node, "[") {
+		host, _, found := strings.Cut(node[1:], "]")
+		if !found {
+			return nil
+		}
+
+		return net.ParseIP(host)
+	}
+
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
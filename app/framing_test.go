@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestContentLengthAndTransferEncodingRejected(t *testing.T) {
+	// the smuggled request must never be answered, the connection is closed
+	// after the 400
+	tests := map[string]string{
+		"canonical": "Content-Length: 5\r\nTransfer-Encoding: chunked\r\n",
+		"lowercase": "content-length: 5\r\ntransfer-encoding: chunked\r\n",
+		"mixed":     "CONTENT-LENGTH: 5\r\nTransfer-encoding: Chunked\r\n",
+		"reversed":  "transfer-encoding: chunked\r\nContent-Length: 5\r\n",
+	}
+
+	for name, headers := range tests {
+		t.Run(name, func(t *testing.T) {
+			output, _ := exchange(t, testConfig(t), "POST /echo/x HTTP/1.1\r\nHost: localhost\r\n"+headers+"\r\n"+
+				"0\r\n\r\nGET /echo/smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+			response := parseResponses(t, output, "POST")[0]
+
+			if response.status != 400 {
+				t.Errorf("got status %d, want 400", response.status)
+			}
+
+			if !response.close {
+				t.Errorf("400 without Connection: close")
+			}
+		})
+	}
+}
+
+func TestTransferEncodingOtherThanChunkedRejected(t *testing.T) {
+	tests := map[string]string{
+		"gzip":             "Transfer-Encoding: gzip\r\n",
+		"list":             "Transfer-Encoding: gzip, chunked\r\n",
+		"repeated":         "Transfer-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n",
+		"repeated mixed":   "transfer-encoding: gzip\r\nTransfer-Encoding: chunked\r\n",
+		"chunked and junk": "Transfer-Encoding: chunked, identity\r\n",
+	}
+
+	for name, headers := range tests {
+		t.Run(name, func(t *testing.T) {
+			response := roundTrip(t, testConfig(t), "POST /files/a HTTP/1.1\r\nHost: localhost\r\n"+headers+"\r\n0\r\n\r\n")
+
+			if response.status != 400 {
+				t.Errorf("got status %d, want 400", response.status)
+			}
+		})
+	}
+}
+
+func TestChunkedTransferEncodingAnyCase(t *testing.T) {
+	cfg := testConfig(t)
+
+	response := roundTrip(t, cfg, "POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\ntransfer-encoding: CHUNKED\r\n\r\n"+
+		"5\r\nhello\r\n0\r\n\r\n")
+
+	if response.status != 201 {
+		t.Fatalf("got status %d, want 201", response.status)
+	}
+
+	get := roundTrip(t, cfg, "GET /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if get.body != "hello" {
+		t.Errorf("got uploaded content %q, want \"hello\"", get.body)
+	}
+}
+
+func TestHeaderNamesCaseInsensitive(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "GET /user-agent HTTP/1.1\r\nHost: localhost\r\nuser-AGENT: probe/1.0\r\n\r\n")
+
+	if response.body != "probe/1.0" {
+		t.Errorf("got body %q, want \"probe/1.0\"", response.body)
+	}
+}
+
+func TestContentLengthValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		status  int
+		body    string
+	}{
+		{"single", "Content-Length: 5\r\n", 201, "hello"},
+		{"duplicate equal", "Content-Length: 5\r\ncontent-length: 5\r\n", 201, "hello"},
+		{"comma list", "Content-Length: 5, 5,5\r\n", 201, "hello"},
+		{"conflicting", "Content-Length: 5\r\nContent-Length: 6\r\n", 400, ""},
+		{"conflicting list", "Content-Length: 5, 6\r\n", 400, ""},
+		{"conflicting case", "Content-Length: 5\r\nCONTENT-LENGTH: 4\r\n", 400, ""},
+		{"empty list entry", "Content-Length: 5,\r\n", 400, ""},
+		{"negative", "Content-Length: -5\r\n", 400, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+
+			response := roundTrip(t, cfg, "PUT /files/a.txt HTTP/1.1\r\nHost: localhost\r\n"+test.headers+"\r\nhello")
+			if response.status != test.status {
+				t.Fatalf("got status %d, want %d", response.status, test.status)
+			}
+
+			if test.status >= 400 {
+				if !response.close {
+					t.Errorf("%d without Connection: close", response.status)
+				}
+
+				return
+			}
+
+			if get := roundTrip(t, cfg, "GET /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n"); get.body != test.body {
+				t.Errorf("got stored body %q, want %q", get.body, test.body)
+			}
+		})
+	}
+}
+
+func TestMergeContentLength(t *testing.T) {
+	tests := []struct {
+		previous string
+		value    string
+		want     string
+		fails    bool
+	}{
+		{"", "7", "7", false},
+		{"7", "7", "7", false},
+		{"", "7 , 7", "7", false},
+		{"7", "7, 7", "7", false},
+		{"7", "8", "", true},
+		{"", "7, 8", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := mergeContentLength(test.previous, test.value)
+		if test.fails {
+			if !errors.Is(err, errMalformedRequest) {
+				t.Errorf("mergeContentLength(%q, %q) = %q, %v, want errMalformedRequest", test.previous, test.value, got, err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != test.want {
+			t.Errorf("mergeContentLength(%q, %q) = %q, %v, want %q", test.previous, test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// acceptsGzip reports whether the Accept-Encoding header of a request allows
+// a gzip encoded response. A q-value of 0 explicitly refuses it.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		q := 1.0
+		if name, value, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+
+			q = parsed
+		}
+
+		if q > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressibleTypes are the media types outside of text/* that are worth
+// compressing. Everything else, like images and archives, is usually
+// compressed already.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+}
+
+// compressible reports whether a body of contentType shrinks when gzipped.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+func gzipBytes(content []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to compress content: %w", err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress content: %w", err)
+	}
+
+	return compressed.Bytes(), nil
+}
+
+// setHeader replaces the value of the header name in headers, adding it when
+// it isn't there yet.
+func setHeader(headers *[]string, name string, value string) {
+	for i, header := range *headers {
+		if existing, _, _ := strings.Cut(header, ":"); strings.EqualFold(existing, name) {
+			(*headers)[i] = name + ": " + value
+			return
+		}
+	}
+
+	*headers = append(*headers, name+": "+value)
+}
+
+// headerValue returns the value of the last header called name in headers, or
+// "" when there's none.
+func headerValue(headers []string, name string) string {
+	var value string
+
+	for _, header := range headers {
+		if existing, v, _ := strings.Cut(header, ":"); strings.EqualFold(existing, name) {
+			value = strings.TrimSpace(v)
+		}
+	}
+
+	return value
+}
+
+// removeHeader drops every header called name from headers.
+func removeHeader(headers *[]string, name string) {
+	kept := (*headers)[:0]
+
+	for _, header := range *headers {
+		if existing, _, _ := strings.Cut(header, ":"); !strings.EqualFold(existing, name) {
+			kept = append(kept, header)
+		}
+	}
+
+	*headers = kept
+}
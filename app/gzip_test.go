@@ -0,0 +1,165 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func gzipConfig(t *testing.T) (*config, string) {
+	t.Helper()
+
+	cfg := testConfig(t)
+	cfg.gzip = true
+
+	content := strings.Repeat("compress me please ", 200)
+	writeFile(t, cfg, "page.txt", content)
+
+	return cfg, content
+}
+
+func gunzip(t *testing.T, body string) string {
+	t.Helper()
+
+	reader, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("body isn't gzip: %v", err)
+	}
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %v", err)
+	}
+
+	return string(decompressed)
+}
+
+func TestGzipCompressesWithWeakETag(t *testing.T) {
+	cfg, content := gzipConfig(t)
+
+	response := roundTrip(t, cfg, "GET /files/page.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+
+	if response.header.Get("Content-Encoding") != "gzip" || gunzip(t, response.body) != content {
+		t.Fatalf("got Content-Encoding %q, want the gzipped file", response.header.Get("Content-Encoding"))
+	}
+
+	if etag := response.header.Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("got ETag %q on a compressed body, want a weak tag", etag)
+	}
+
+	if response.header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("got Vary %q, want Accept-Encoding", response.header.Get("Vary"))
+	}
+}
+
+func TestGzipRangeServedUncompressed(t *testing.T) {
+	cfg, content := gzipConfig(t)
+
+	response := roundTrip(t, cfg, "GET /files/page.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nRange: bytes=0-99\r\n\r\n")
+
+	if response.status != 206 {
+		t.Fatalf("got status %d, want 206", response.status)
+	}
+
+	if response.header.Get("Content-Encoding") != "" {
+		t.Errorf("206 sent with Content-Encoding %q", response.header.Get("Content-Encoding"))
+	}
+
+	if response.body != content[:100] {
+		t.Errorf("got range %q, want the first 100 bytes of the file", response.body)
+	}
+
+	if etag := response.header.Get("ETag"); strings.HasPrefix(etag, "W/") {
+		t.Errorf("got weak ETag %q on an uncompressed range", etag)
+	}
+}
+
+func TestGzipWeakETagInConditionalRequests(t *testing.T) {
+	cfg, content := gzipConfig(t)
+
+	compressed := roundTrip(t, cfg, "GET /files/page.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	weak := compressed.header.Get("ETag")
+
+	// the weak tag still revalidates the cached compressed copy
+	response := roundTrip(t, cfg, "GET /files/page.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nIf-None-Match: "+weak+"\r\n\r\n")
+	if response.status != 304 {
+		t.Errorf("got status %d for If-None-Match with the weak tag, want 304", response.status)
+	}
+
+	// but it can't be used to resume, the whole file is sent instead
+	response = roundTrip(t, cfg, "GET /files/page.txt HTTP/1.1\r\nHost: localhost\r\nIf-Range: "+weak+"\r\nRange: bytes=0-9\r\n\r\n")
+	if response.status != 200 || response.body != content {
+		t.Errorf("got %d with %d bytes for If-Range with the weak tag, want the whole file", response.status, len(response.body))
+	}
+}
+
+func TestGzipNotAcceptedStreamsPlainFile(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.gzip = true
+
+	content := strings.Repeat("a", 2*maxSingleWrite)
+	writeFile(t, cfg, "big.txt", content)
+
+	response := roundTrip(t, cfg, "GET /files/big.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: identity\r\n\r\n")
+
+	if response.header.Get("Content-Encoding") != "" || response.body != content {
+		t.Errorf("got Content-Encoding %q, want the plain file", response.header.Get("Content-Encoding"))
+	}
+}
+
+func TestGzipSkipsSmallBodies(t *testing.T) {
+	cfg, _ := gzipConfig(t)
+	writeFile(t, cfg, "small.txt", "too small to bother")
+
+	for _, path := range []string{"/files/small.txt", "/echo/small"} {
+		response := roundTrip(t, cfg, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+
+		if response.header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: compressed a body below -gzip-min-size", path)
+		}
+	}
+
+	// the threshold is configurable
+	cfg.gzipMinSize = 10
+
+	response := roundTrip(t, cfg, "GET /files/small.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	if response.header.Get("Content-Encoding") != "gzip" || gunzip(t, response.body) != "too small to bother" {
+		t.Errorf("got Content-Encoding %q with -gzip-min-size=10, want gzip", response.header.Get("Content-Encoding"))
+	}
+}
+
+func TestGzipSkipsCompressedTypes(t *testing.T) {
+	cfg, _ := gzipConfig(t)
+
+	// large enough to compress if it were text
+	jpeg := "\xff\xd8\xff\xe0" + strings.Repeat("x", 4096)
+	writeFile(t, cfg, "photo.jpg", jpeg)
+	writeFile(t, cfg, "bundle.zip", "PK\x03\x04"+strings.Repeat("x", 4096))
+
+	for _, name := range []string{"photo.jpg", "bundle.zip"} {
+		response := roundTrip(t, cfg, "GET /files/"+name+" HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+
+		if response.header.Get("Content-Encoding") != "" {
+			t.Errorf("%s served as %q with Content-Encoding %q", name, response.header.Get("Content-Type"), response.header.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	tests := map[string]bool{
+		"text/html; charset=utf-8": true,
+		"application/json":         true,
+		"application/javascript":   true,
+		"image/jpeg":               false,
+		"application/zip":          false,
+		"application/octet-stream": false,
+		"":                         false,
+	}
+
+	for contentType, want := range tests {
+		if got := compressible(contentType); got != want {
+			t.Errorf("compressible(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
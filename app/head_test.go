@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withoutDate drops the Date header, which may differ between two responses.
+func withoutDate(response testResponse) testResponse {
+	response.header = response.header.Clone()
+	response.header.Del("Date")
+	return response
+}
+
+func TestHeadRoot(t *testing.T) {
+	cfg := testConfig(t)
+
+	// nothing may follow the head, or the pipelined GET would be misread
+	output, _ := exchange(t, cfg, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if head, _, _ := strings.Cut(output, "\r\n\r\n"); !strings.Contains(head, "Content-Length: 0") {
+		t.Errorf("HEAD / without Content-Length: 0:\n%s", head)
+	}
+
+	responses := parseResponses(t, output, "HEAD", "GET")
+	get := roundTrip(t, cfg, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if head := withoutDate(responses[0]); head.status != 200 || !reflect.DeepEqual(head.header, withoutDate(get).header) {
+		t.Errorf("got HEAD %d %v, want the headers of GET %v", head.status, head.header, get.header)
+	}
+
+	if responses[1].body != "next" {
+		t.Errorf("got %q after HEAD /, want \"next\"", responses[1].body)
+	}
+}
+
+func TestHeadRootAs204(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.emptyAs204 = true
+
+	output, _ := exchange(t, cfg, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response := parseResponses(t, output, "HEAD")[0]; response.status != 204 {
+		t.Errorf("got status %d with -empty-as-204, want 204", response.status)
+	}
+}
+
+func TestHeadFile(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "a.txt", "hello")
+
+	output, _ := exchange(t, cfg, "HEAD /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	response := parseResponses(t, output, "HEAD")[0]
+	if response.status != 200 || response.header.Get("Content-Length") != "5" || response.body != "" {
+		t.Errorf("got %d with Content-Length %q and body %q, want 200 with the length of the file", response.status, response.header.Get("Content-Length"), response.body)
+	}
+
+	if strings.Contains(output, "hello") {
+		t.Errorf("HEAD sent the file")
+	}
+}
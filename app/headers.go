@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// repeatableHeaders may appear more than once in a response, every other
+// header is sent at most once.
+var repeatableHeaders = map[string]bool{
+	"Link":       true,
+	"Set-Cookie": true,
+	"Vary":       true,
+}
+
+// defaultHeaders are added to every final response from -header, unless the
+// response already has a header of the same name.
+var defaultHeaders responseHeaders
+
+// responseHeaders holds "Name: value" headers given as a flag once per header.
+type responseHeaders []string
+
+func (h *responseHeaders) String() string {
+	if h == nil {
+		return ""
+	}
+
+	return strings.Join(*h, "; ")
+}
+
+func (h *responseHeaders) Set(value string) error {
+	name, headerValue, found := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	headerValue = strings.TrimSpace(headerValue)
+
+	if !found || name == "" || strings.ContainsAny(name, " \t") || headerValue == "" {
+		return fmt.Errorf("expected a header like Name: value, got %q", value)
+	}
+
+	// the server frames and manages the connection itself
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if name == "Content-Length" || name == "Transfer-Encoding" || name == "Connection" {
+		return fmt.Errorf("%s can't be set with -header", name)
+	}
+
+	*h = append(*h, name+": "+headerValue)
+
+	return nil
+}
+
+// mergeHeaders drops repeated headers from computed, keeping the first of
+// each, and appends the defaults the response doesn't already have, so the
+// headers the server computes always win.
+func mergeHeaders(computed []string, defaults []string) []string {
+	seen := make(map[string]bool, len(computed))
+	merged := make([]string, 0, len(computed)+len(defaults))
+
+	for _, header := range computed {
+		name, _, _ := strings.Cut(header, ":")
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+
+		if seen[name] && !repeatableHeaders[name] {
+			continue
+		}
+
+		seen[name] = true
+		merged = append(merged, header)
+	}
+
+	return appendDefaults(merged, seen, defaults)
+}
+
+// relayHeaders appends the defaults a relayed response doesn't already have
+// to headers, which are left exactly as they came, repeats included.
+func relayHeaders(headers []string, defaults []string) []string {
+	seen := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		name, _, _ := strings.Cut(header, ":")
+		seen[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))] = true
+	}
+
+	return appendDefaults(append([]string(nil), headers...), seen, defaults)
+}
+
+// appendDefaults appends to headers the defaults whose names aren't seen.
+func appendDefaults(headers []string, seen map[string]bool, defaults []string) []string {
+	added := make(map[string]bool, len(defaults))
+
+	for _, header := range defaults {
+		name, _, _ := strings.Cut(header, ":")
+		if seen[name] || (added[name] && !repeatableHeaders[name]) {
+			continue
+		}
+
+		added[name] = true
+		headers = append(headers, header)
+	}
+
+	return headers
+}
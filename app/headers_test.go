@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withDefaultHeaders sets -header values for the duration of the test.
+func withDefaultHeaders(t *testing.T, values ...string) {
+	t.Helper()
+
+	saved := defaultHeaders
+	t.Cleanup(func() { defaultHeaders = saved })
+
+	defaultHeaders = nil
+	for _, value := range values {
+		if err := defaultHeaders.Set(value); err != nil {
+			t.Fatalf("failed to set -header %q: %v", value, err)
+		}
+	}
+}
+
+func TestComputedHeadersWinOverDefaults(t *testing.T) {
+	withDefaultHeaders(t, "content-type: application/x-custom", "X-Served-By: test")
+
+	output, _ := exchange(t, testConfig(t), "GET /echo/hello HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if head, _, _ := strings.Cut(output, "\r\n\r\n"); strings.Count(strings.ToLower(head), "content-type:") != 1 {
+		t.Errorf("Content-Type sent more than once:\n%s", head)
+	}
+
+	response := parseResponses(t, output, "GET")[0]
+
+	if got := response.header.Values("Content-Type"); !reflect.DeepEqual(got, []string{"text/plain"}) {
+		t.Errorf("got Content-Type %q, want the computed text/plain", got)
+	}
+
+	if got := response.header.Get("X-Served-By"); got != "test" {
+		t.Errorf("got X-Served-By %q, want the default", got)
+	}
+}
+
+func TestDefaultHeaderFillsMissing(t *testing.T) {
+	withDefaultHeaders(t, "Content-Type: application/x-custom")
+
+	// the root response has no Content-Type of its own
+	response := roundTrip(t, testConfig(t), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if got := response.header.Get("Content-Type"); got != "application/x-custom" {
+		t.Errorf("got Content-Type %q, want the default", got)
+	}
+}
+
+func TestMergeHeaders(t *testing.T) {
+	got := mergeHeaders(
+		[]string{"Content-Type: text/html", "content-type: text/plain", "Set-Cookie: a=1", "Set-Cookie: b=2"},
+		[]string{"Content-Type: application/x-custom", "Set-Cookie: c=3", "X-Extra: 1"},
+	)
+
+	want := []string{"Content-Type: text/html", "Set-Cookie: a=1", "Set-Cookie: b=2", "X-Extra: 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDefaultHeaderRejectsFraming(t *testing.T) {
+	var headers responseHeaders
+
+	for _, value := range []string{"Content-Length: 5", "transfer-encoding: chunked", "Connection: close", "X-Empty:", "No colon"} {
+		if err := headers.Set(value); err == nil {
+			t.Errorf("accepted -header %q", value)
+		}
+	}
+}
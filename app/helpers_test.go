@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// the diagnostic log would only clutter the test output
+	logger.writer = io.Discard
+
+	os.Exit(m.Run())
+}
+
+// testConfig returns the config main builds with every flag at its default,
+// serving a new temporary directory.
+func testConfig(t testing.TB) *config {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	accessLog, err := newAccessLogger("text", io.Discard)
+	if err != nil {
+		t.Fatalf("failed to create access logger: %v", err)
+	}
+
+	return &config{
+		filesDir:        dir,
+		files:           os.DirFS(dir),
+		timeout:         defaultTimeout,
+		idleTimeout:     defaultIdleTimeout,
+		maxRequestLine:  defaultMaxRequestLine,
+		ranges:          true,
+		gzipMinSize:     1024,
+		chunkSize:       defaultStreamChunkSize,
+		errorFormat:     "text",
+		methods:         implementedMethods,
+		indexFiles:      []string{"index.html"},
+		cacheControl:    &cacheControlRules{},
+		uploads:         newUploadTracker(),
+		accessLog:       accessLog,
+		metrics:         newMetrics(),
+		lifecycle:       &lifecycle{},
+		upstreamTimeout: 3 * time.Second,
+	}
+}
+
+// writeFile creates name under the files directory of cfg with content.
+func writeFile(t testing.TB, cfg *config, name string, content string) string {
+	t.Helper()
+
+	fileName := filepath.Join(cfg.filesDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatalf("failed to create directory for %s: %v", name, err)
+	}
+
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+
+	return fileName
+}
+
+// scriptedConn is a connection whose client sent input and then closed its
+// side. Everything the server writes is collected in output.
+type scriptedConn struct {
+	input  io.Reader
+	output bytes.Buffer
+}
+
+func (c *scriptedConn) Read(p []byte) (int, error)         { return c.input.Read(p) }
+func (c *scriptedConn) Write(p []byte) (int, error)        { return c.output.Write(p) }
+func (c *scriptedConn) Close() error                       { return nil }
+func (c *scriptedConn) LocalAddr() net.Addr                { return testAddr }
+func (c *scriptedConn) RemoteAddr() net.Addr               { return testAddr }
+func (c *scriptedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *scriptedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *scriptedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+var testAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+
+// exchange serves the raw requests as a client that sends them all and then
+// half-closes the connection would, returning everything the server wrote
+// until it was done with the connection, and the error handle returned.
+func exchange(t *testing.T, cfg *config, raw string) (string, error) {
+	t.Helper()
+
+	conn := &scriptedConn{input: strings.NewReader(raw)}
+
+	c, err := newConnection(conn, cfg)
+	if err != nil {
+		t.Fatalf("failed to create connection: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer c.close()
+		done <- c.handle()
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("connection still being served after 10s")
+	}
+
+	return conn.output.String(), err
+}
+
+// testResponse is a response parsed from the server's output.
+type testResponse struct {
+	status int
+	header http.Header
+	body   string
+
+	// trailer holds the trailer fields of a chunked response
+	trailer http.Header
+
+	// close is set when the response had Connection: close, which
+	// http.ReadResponse takes out of header
+	close bool
+}
+
+// parseResponses splits output into the responses to requests with the given
+// methods, in order. Output left after them fails the test.
+func parseResponses(t *testing.T, output string, methods ...string) []testResponse {
+	t.Helper()
+
+	reader := bufio.NewReader(strings.NewReader(output))
+
+	var responses []testResponse
+	for _, method := range methods {
+		response, err := http.ReadResponse(reader, &http.Request{Method: method})
+		if err != nil {
+			t.Fatalf("failed to parse response %d to %s from %q: %v", len(responses)+1, method, output, err)
+		}
+
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatalf("failed to read body of response %d from %q: %v", len(responses)+1, output, err)
+		}
+
+		responses = append(responses, testResponse{
+			status:  response.StatusCode,
+			header:  response.Header,
+			body:    string(body),
+			trailer: response.Trailer,
+			close:   response.Close,
+		})
+	}
+
+	if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+		t.Fatalf("unexpected output after %d responses: %q", len(responses), rest)
+	}
+
+	return responses
+}
+
+// roundTrip serves a single request and parses the only response to it.
+func roundTrip(t *testing.T, cfg *config, raw string) testResponse {
+	t.Helper()
+
+	method, _, _ := strings.Cut(raw, " ")
+
+	output, _ := exchange(t, cfg, raw)
+
+	return parseResponses(t, output, method)[0]
+}
+
+// dial serves cfg on one end of a net.Pipe and returns the other, for tests
+// that depend on when the client sends. The connection is closed when the
+// test ends.
+func dial(t *testing.T, cfg *config) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	server, client := net.Pipe()
+
+	c, err := newConnection(server, cfg)
+	if err != nil {
+		t.Fatalf("failed to create connection: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer c.close()
+		c.handle()
+	}()
+
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	return client, bufio.NewReader(client)
+}
+
+// readResponse reads the next response to a request with method from a
+// connection made with dial.
+func readResponse(t *testing.T, reader *bufio.Reader, method string) testResponse {
+	t.Helper()
+
+	response, err := http.ReadResponse(reader, &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("failed to read response to %s: %v", method, err)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read body of response to %s: %v", method, err)
+	}
+
+	return testResponse{
+		status:  response.StatusCode,
+		header:  response.Header,
+		body:    string(body),
+		trailer: response.Trailer,
+		close:   response.Close,
+	}
+}
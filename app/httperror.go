@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// httpError is returned by a handler to have the request answered with an
+// error response instead of sending it itself. The status is one of the
+// status lines, the message the body of the response.
+type httpError struct {
+	status  string
+	message string
+
+	// headers are sent along with the error response
+	headers []string
+
+	// err is the cause, logged but never shown to the client
+	err error
+}
+
+func newHTTPError(status string, message string) *httpError {
+	return &httpError{status: status, message: message}
+}
+
+func (e *httpError) Error() string {
+	text := e.status
+	if e.message != "" {
+		text += ": " + e.message
+	}
+
+	if e.err != nil {
+		text += ": " + e.err.Error()
+	}
+
+	return text
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+// respondError answers a request whose handler failed with err. An httpError
+// is sent as its error response and the request counts as handled, so nil is
+// returned. Any other error gets a 500 unless the handler already responded,
+// and is returned so that the connection is closed.
+func (c *connection) respondError(ctx context.Context, err error) error {
+	var httpErr *httpError
+	if !errors.As(err, &httpErr) {
+		if c.status == 0 && !isClientAbort(err) {
+			c.connectionHeaders = []string{"Connection: close"}
+			c.send(ctx, c.errorResponse(ctx, internal_server_error, nil, ""))
+		}
+
+		return err
+	}
+
+	if httpErr.err != nil {
+		if statusCode([]byte(httpErr.status)) >= 500 {
+			logger.errorf("Failed to handle request %s: %v", requestID(ctx), httpErr.err)
+		} else {
+			logger.debugf("Rejected request %s: %v", requestID(ctx), httpErr.err)
+		}
+	}
+
+	// the handler may have started its response before failing, there's no
+	// way to replace it then
+	if c.status != 0 {
+		return err
+	}
+
+	headers := httpErr.headers
+	if err := c.send(ctx, c.errorResponse(ctx, httpErr.status, &headers, httpErr.message)); err != nil {
+		return fmt.Errorf("failed to send error response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerErrorsKeepConnection(t *testing.T) {
+	// each request is rejected by its handler, the echo after it shows the
+	// connection was still usable
+	tests := []struct {
+		name    string
+		request string
+		status  int
+		header  string
+	}{
+		{"trace with body", "TRACE / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc", 400, ""},
+		{"proxy disabled", "GET /proxy/x HTTP/1.1\r\nHost: localhost\r\n\r\n", 404, ""},
+		{"websocket without upgrade", "GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n", 426, "Upgrade"},
+		{"multipart without boundary", "POST /files/ HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data\r\nContent-Length: 0\r\n\r\n", 400, ""},
+		{"invalid delay", "GET /delay/soon HTTP/1.1\r\nHost: localhost\r\n\r\n", 400, ""},
+		{"range upload without range", "PUT /files/a HTTP/1.1\r\nHost: localhost\r\nContent-Range: bytes */*\r\nContent-Length: 0\r\n\r\n", 400, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method, _, _ := strings.Cut(test.request, " ")
+
+			output, err := exchange(t, testConfig(t), test.request+"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			if err != nil {
+				t.Fatalf("handle failed: %v", err)
+			}
+
+			responses := parseResponses(t, output, method, "GET")
+
+			if responses[0].status != test.status {
+				t.Errorf("got status %d, want %d", responses[0].status, test.status)
+			}
+
+			if test.header != "" && responses[0].header.Get(test.header) == "" {
+				t.Errorf("error response without %s", test.header)
+			}
+
+			if responses[1].body != "next" {
+				t.Errorf("got %d %q after the error, want \"next\"", responses[1].status, responses[1].body)
+			}
+		})
+	}
+}
+
+func TestPermissionErrorsSurviveWrapping(t *testing.T) {
+	// os.IsPermission doesn't look through %w, which turned a 403 into a 500
+	err := fmt.Errorf("failed to delete a: %w", &fs.PathError{Op: "remove", Path: "a", Err: os.ErrPermission})
+
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("wrapped permission error not recognized")
+	}
+}
+
+func TestNonHTTPErrorClosesConnection(t *testing.T) {
+	cfg := testConfig(t)
+	conn := &scriptedConn{}
+
+	c, _ := newConnection(conn, cfg)
+	ctx := withRequest(context.Background(), "test", time.Now())
+
+	if err := c.respondError(ctx, errors.New("handler broke")); err == nil {
+		t.Fatalf("respondError returned nil for a plain error")
+	}
+
+	response := parseResponses(t, conn.output.String(), "GET")[0]
+	if response.status != 500 || !response.close {
+		t.Errorf("got %d close=%v, want 500 with Connection: close", response.status, response.close)
+	}
+}
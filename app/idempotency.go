@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotentResult is the outcome of the first POST made with a key.
+type idempotentResult struct {
+	path    string
+	status  int
+	pending bool
+	expires time.Time
+}
+
+// idempotencyStore remembers the result of POST requests carrying an
+// Idempotency-Key so that a retried upload gets the original response instead
+// of creating the file again. Entries expire after ttl.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotentResult
+}
+
+// newIdempotencyStore creates a store and starts evicting its expired entries
+// in the background for the lifetime of the process.
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	s := &idempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotentResult),
+	}
+
+	interval := ttl
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	go func() {
+		for now := range time.Tick(interval) {
+			s.evict(now)
+		}
+	}()
+
+	return s
+}
+
+func (s *idempotencyStore) evict(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.entries {
+		if !entry.pending && now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// begin claims key for a request to path. If the key was already used, the
+// stored result is returned instead and the request must not be processed.
+func (s *idempotencyStore) begin(key string, path string) (idempotentResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && (entry.pending || time.Now().Before(entry.expires)) {
+		return *entry, true
+	}
+
+	s.entries[key] = &idempotentResult{path: path, pending: true}
+
+	return idempotentResult{}, false
+}
+
+// complete stores the status of the request that claimed key.
+func (s *idempotencyStore) complete(key string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		entry.status = status
+		entry.pending = false
+		entry.expires = time.Now().Add(s.ttl)
+	}
+}
+
+// abandon releases key after a failed request so that a retry is processed.
+func (s *idempotencyStore) abandon(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+// replayIdempotent answers a POST whose Idempotency-Key was already used.
+func (c *connection) replayIdempotent(ctx context.Context, previous idempotentResult, path string) error {
+	if previous.pending {
+		return newHTTPError(conflict, "a request with this Idempotency-Key is in progress")
+	}
+
+	if previous.path != path {
+		return newHTTPError(unprocessable_entity, "Idempotency-Key was used for a different path")
+	}
+
+	headers := []string{"Idempotent-Replayed: true"}
+	if err := c.send(ctx, buildResponse(statusLine(previous.status), &headers, "")); err != nil {
+		return fmt.Errorf("failed to send replayed response for idempotent request: %w", err)
+	}
+
+	return nil
+}
+
+// idempotencyKey returns the trimmed Idempotency-Key header of request.
+func idempotencyKey(request *request) string {
+	return strings.TrimSpace(request.headers["Idempotency-Key"])
+}
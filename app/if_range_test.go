@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIfRange(t *testing.T) {
+	cfg := testConfig(t)
+	fileName := writeFile(t, cfg, "digits.txt", "0123456789")
+
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(fileName, modified, modified); err != nil {
+		t.Fatalf("failed to set modification time: %v", err)
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+
+	tests := []struct {
+		ifRange string
+		want    int
+	}{
+		{fileETag(info), 206},
+		{"W/" + fileETag(info), 200},
+		{`"stale"`, 200},
+		{modified.Format(http.TimeFormat), 206},
+		{modified.Format(time.RFC850), 206},
+		{modified.Format(time.ANSIC), 206},
+		{modified.Add(time.Hour).Format(http.TimeFormat), 200},
+		{modified.Add(-time.Hour).Format(http.TimeFormat), 200},
+		{"Friday", 200},
+	}
+
+	for _, test := range tests {
+		ifRange, want := test.ifRange, test.want
+		response := roundTrip(t, cfg, "GET /files/digits.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=2-4\r\nIf-Range: "+ifRange+"\r\n\r\n")
+
+		wantBody := "234"
+		if want == 200 {
+			wantBody = "0123456789"
+		}
+
+		if response.status != want || response.body != wantBody {
+			t.Errorf("If-Range %q: got %d %q, want %d %q", ifRange, response.status, response.body, want, wantBody)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyRange is the artificial delay added before every response with
+// -inject-latency, given as "min-max" for a random delay in between or as a
+// single fixed duration. The zero value adds no delay.
+type latencyRange struct {
+	min time.Duration
+	max time.Duration
+}
+
+func (r *latencyRange) String() string {
+	if r == nil || r.max == 0 {
+		return ""
+	}
+
+	if r.min == r.max {
+		return r.min.String()
+	}
+
+	return r.min.String() + "-" + r.max.String()
+}
+
+func (r *latencyRange) Set(value string) error {
+	first, last, found := strings.Cut(value, "-")
+	if !found {
+		last = first
+	}
+
+	min, err := time.ParseDuration(strings.TrimSpace(first))
+	if err != nil {
+		return fmt.Errorf("invalid latency %q, expected a duration or min-max: %w", value, err)
+	}
+
+	max, err := time.ParseDuration(strings.TrimSpace(last))
+	if err != nil {
+		return fmt.Errorf("invalid latency %q, expected a duration or min-max: %w", value, err)
+	}
+
+	if min < 0 || max < min {
+		return fmt.Errorf("invalid latency %q, expected 0 <= min <= max", value)
+	}
+
+	r.min, r.max = min, max
+
+	return nil
+}
+
+var (
+	latencyMu   sync.Mutex
+	latencyRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// pick returns a random delay within the range.
+func (r latencyRange) pick() time.Duration {
+	if r.max == r.min {
+		return r.min
+	}
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	return r.min + time.Duration(latencyRand.Int63n(int64(r.max-r.min)+1))
+}
+
+// latencyMargin is the time left before the request deadline that injected
+// latency never eats into, so the response still has time to go out.
+const latencyMargin = 50 * time.Millisecond
+
+// injectLatency waits for a delay picked from the -inject-latency range, or
+// until ctx is done. The delay is cut short to end latencyMargin before the
+// deadline of ctx, a route timeout or a slow handler can leave less time than
+// the -timeout the range was checked against.
+func (c *connection) injectLatency(ctx context.Context) error {
+	delay := c.latency.pick()
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline) - latencyMargin; left < delay {
+			delay = left
+		}
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInjectedLatency(t *testing.T) {
+	cfg := testConfig(t)
+	if err := cfg.latency.Set("100ms"); err != nil {
+		t.Fatalf("failed to set latency: %v", err)
+	}
+
+	start := time.Now()
+
+	response := roundTrip(t, cfg, "GET /echo/late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 200 || response.body != "late" {
+		t.Errorf("got %d %q, want 200 \"late\"", response.status, response.body)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("answered after %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestInjectedLatencyBeyondDeadline(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.timeout = 200 * time.Millisecond
+
+	// main refuses this, a route timeout can still leave less time than the
+	// latency
+	if err := cfg.latency.Set("1s"); err != nil {
+		t.Fatalf("failed to set latency: %v", err)
+	}
+
+	output, err := exchange(t, cfg, "GET /echo/late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if output == "" {
+		t.Fatalf("no response written, handle returned %v", err)
+	}
+
+	if response := parseResponses(t, output, "GET")[0]; response.status != 200 || response.body != "late" {
+		t.Errorf("got %d %q, want 200 \"late\"", response.status, response.body)
+	}
+}
+
+func TestLatencyRange(t *testing.T) {
+	tests := map[string]latencyRange{
+		"50ms":      {50 * time.Millisecond, 50 * time.Millisecond},
+		"10ms-20ms": {10 * time.Millisecond, 20 * time.Millisecond},
+		" 1s - 2s ": {time.Second, 2 * time.Second},
+		"0s":        {},
+	}
+
+	for value, want := range tests {
+		var got latencyRange
+		if err := got.Set(value); err != nil || got != want {
+			t.Errorf("Set(%q) = %v, %v, want %v", value, got, err, want)
+		}
+
+		if delay := got.pick(); delay < want.min || delay > want.max {
+			t.Errorf("picked %v from %q", delay, value)
+		}
+	}
+
+	for _, value := range []string{"soon", "20ms-10ms", "-5ms", "1s-"} {
+		var r latencyRange
+		if err := r.Set(value); err == nil {
+			t.Errorf("accepted latency %q", value)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// lifecycle tracks where the server is between startup and shutdown. It backs
+// the /readyz endpoint and lets connections know when to stop serving.
+type lifecycle struct {
+	ready    atomic.Bool
+	draining atomic.Bool
+
+	// connections counts the connections still being served
+	connections sync.WaitGroup
+}
+
+// shutdownOnSignal waits for SIGINT or SIGTERM and then takes the server out
+// of rotation: it reports not ready straight away, keeps accepting for delay
+// so load balancers notice, then closes the listener. Connections finish the
+// request they're on and close instead of waiting for another one.
+func (lc *lifecycle) shutdownOnSignal(l net.Listener, delay time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		logger.infof("Received %v, shutting down", sig)
+
+		lc.ready.Store(false)
+		lc.draining.Store(true)
+
+		time.Sleep(delay)
+
+		l.Close()
+	}()
+}
+
+// drain waits for open connections to finish, giving up after timeout.
+func (lc *lifecycle) drain(timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		lc.connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// refuseRetryAfter is how long a refused client is asked to wait before it
+// tries again.
+const refuseRetryAfter = time.Second
+
+// A refused connection's unread request is discarded for up to refuseDrain,
+// and at most refuseDrainLimit bytes of it, before the connection is closed.
+const (
+	refuseDrain      = 500 * time.Millisecond
+	refuseDrainLimit = 64 * 1024
+)
+
+// connLimiter caps the number of connections the server has open at once.
+type connLimiter struct {
+	slots chan struct{}
+}
+
+func newConnLimiter(limit int) *connLimiter {
+	return &connLimiter{slots: make(chan struct{}, limit)}
+}
+
+// acquire counts a new connection, waiting for another one to close if the
+// limit is reached.
+func (l *connLimiter) acquire() {
+	l.slots <- struct{}{}
+}
+
+// tryAcquire counts a new connection, reporting false without counting it
+// when the limit is reached.
+func (l *connLimiter) tryAcquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release uncounts a closed connection.
+func (l *connLimiter) release() {
+	<-l.slots
+}
+
+// ipLimiter caps the number of connections open at once from a single client
+// IP, so one client can't take up every connection the server has.
+type ipLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	active map[string]int
+}
+
+func newIPLimiter(limit int) *ipLimiter {
+	return &ipLimiter{limit: limit, active: make(map[string]int)}
+}
+
+// acquire counts a new connection from ip, reporting false without counting
+// it when ip already has limit connections open.
+func (l *ipLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[ip] >= l.limit {
+		return false
+	}
+
+	l.active[ip]++
+
+	return true
+}
+
+// release uncounts a closed connection from ip.
+func (l *ipLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// drop idle clients so the map only holds the ones connected right now
+	if l.active[ip]--; l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+}
+
+// remoteIP returns the IP address of the peer of conn.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return host
+}
+
+// refuse answers a connection the server won't serve with a 503 and closes
+// it. The request is never parsed, but closing with it unread would have the
+// kernel reset the connection, which can destroy the 503 before the client
+// reads it. So the write side is shut down first and whatever the client
+// sends is discarded for a short while.
+func refuse(conn net.Conn, message string) {
+	defer conn.Close()
+
+	headers := []string{
+		"Connection: close",
+		"Content-Type: text/plain",
+		fmt.Sprintf("Retry-After: %d", int(refuseRetryAfter.Seconds())),
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(buildResponse(service_unavailable, &headers, message)); err != nil {
+		return
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(refuseDrain))
+	io.Copy(io.Discard, io.LimitReader(conn, refuseDrainLimit))
+}
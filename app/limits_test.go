@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefuseSurvivesUnreadRequest(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			refuse(conn, "server is at capacity")
+		}
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// a request with a body the server never reads, closing on it without
+	// draining would reset the connection
+	go client.Write([]byte("POST /files/a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 20000\r\n\r\n" + strings.Repeat("x", 20000)))
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	response, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if response.StatusCode != 503 || string(body) != "server is at capacity" {
+		t.Errorf("got %d %q, want 503 \"server is at capacity\"", response.StatusCode, body)
+	}
+
+	if response.Header.Get("Retry-After") != "1" {
+		t.Errorf("got Retry-After %q, want 1", response.Header.Get("Retry-After"))
+	}
+}
+
+func TestRefuseStopsDraining(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		refuse(server, "too many connections")
+		close(done)
+	}()
+
+	// read the 503 but never stop sending, refuse must give up on its own
+	go io.Copy(io.Discard, client)
+	go func() {
+		for {
+			if _, err := client.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("refuse still draining after 5s")
+	}
+}
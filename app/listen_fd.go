@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+var errNotListening = errors.New("socket is not listening")
+
+// inheritListener returns the listener on fd that was bound and handed down
+// by the parent process, as with systemd socket activation where the first
+// socket is fd 3. The socket has to be listening already.
+func inheritListener(fd int) (net.Listener, error) {
+	file := os.NewFile(uintptr(fd), "listener")
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+
+	// FileListener works on a copy of the descriptor
+	defer file.Close()
+
+	l, err := net.FileListener(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkListening(l); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// checkListening fails with errNotListening unless listen was called on the
+// socket of l, which would otherwise only fail once connections are accepted.
+func checkListening(l net.Listener) error {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var accepting int
+	var sockErr error
+
+	err = raw.Control(func(fd uintptr) {
+		accepting, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	})
+	if err == nil {
+		err = sockErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to check socket state: %w", err)
+	}
+
+	if accepting == 0 {
+		return errNotListening
+	}
+
+	return nil
+}
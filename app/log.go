@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// accessEntry describes a single handled request.
+type accessEntry struct {
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	RemoteAddr string  `json:"remote_addr"`
+	RequestID  string  `json:"request_id"`
+	User       string  `json:"user,omitempty"`
+
+	// BodyPreview is the escaped start of the request body, only recorded
+	// with -log-body
+	BodyPreview string `json:"body_preview,omitempty"`
+}
+
+// accessLogger records one entry per handled request. Implementations must be
+// safe for concurrent use since every connection logs from its own goroutine.
+type accessLogger interface {
+	log(entry accessEntry)
+}
+
+type textLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func (l *textLogger) log(entry accessEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the user takes the place of the remote user in the common log format
+	user := entry.User
+	if user == "" {
+		user = "-"
+	}
+
+	fmt.Fprintf(
+		l.writer,
+		"%s %s \"%s %s\" %d %d %.3fms id=%s",
+		entry.RemoteAddr,
+		user,
+		entry.Method,
+		entry.Path,
+		entry.Status,
+		entry.Bytes,
+		entry.DurationMs,
+		entry.RequestID,
+	)
+
+	if entry.BodyPreview != "" {
+		fmt.Fprintf(l.writer, " body=\"%s\"", entry.BodyPreview)
+	}
+
+	fmt.Fprintln(l.writer)
+}
+
+type jsonLogger struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func (l *jsonLogger) log(entry accessEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Encode terminates every object with a newline
+	l.encoder.Encode(entry)
+}
+
+func newAccessLogger(format string, writer io.Writer) (accessLogger, error) {
+	switch format {
+	case "text":
+		return &textLogger{writer: writer}, nil
+	case "json":
+		return &jsonLogger{encoder: json.NewEncoder(writer)}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// statusCode extracts the status code from the status line at the start of an
+// HTTP response, returning 0 if message doesn't start with one.
+func statusCode(message []byte) int {
+	if len(message) > 64 {
+		message = message[:64]
+	}
+
+	line := string(message)
+	if end := strings.Index(line, "\r\n"); end != -1 {
+		line = line[:end]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return 0
+	}
+
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0
+	}
+
+	return code
+}
+
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var logLevelNames = map[string]logLevel{
+	"error": levelError,
+	"warn":  levelWarn,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q, expected error, warn, info or debug", name)
+	}
+
+	return level, nil
+}
+
+func (l logLevel) String() string {
+	for name, level := range logLevelNames {
+		if level == l {
+			return name
+		}
+	}
+
+	return strconv.Itoa(int(l))
+}
+
+// leveledLogger writes diagnostic messages at or above its configured level.
+type leveledLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	level  logLevel
+}
+
+// logger is the server's diagnostic log. Its level is set from -log-level
+// once flags are parsed.
+var logger = &leveledLogger{writer: os.Stdout, level: levelInfo}
+
+func (l *leveledLogger) enabled(level logLevel) bool {
+	return level <= l.level
+}
+
+func (l *leveledLogger) logf(level logLevel, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintf(l.writer, "%s: %s\n", level, fmt.Sprintf(format, args...))
+}
+
+func (l *leveledLogger) errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
+
+func (l *leveledLogger) warnf(format string, args ...interface{}) {
+	l.logf(levelWarn, format, args...)
+}
+
+func (l *leveledLogger) infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+func (l *leveledLogger) debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+// isClientAbort reports whether err was caused by the client going away or
+// stalling rather than by the server failing.
+func isClientAbort(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// digestCache remembers the Content-MD5 of served files so a file is only
+// hashed again once it changes. Entries are keyed by path and checked against
+// the modification time and size the file had when it was hashed.
+type digestCache struct {
+	mu      sync.Mutex
+	entries map[string]digestEntry
+}
+
+type digestEntry struct {
+	modTime time.Time
+	size    int64
+	digest  string
+}
+
+func newDigestCache() *digestCache {
+	return &digestCache{entries: make(map[string]digestEntry)}
+}
+
+// contentMD5 returns the base64 encoded MD5 of content, the current content
+// of the file name described by info.
+func (d *digestCache) contentMD5(name string, info fs.FileInfo, content []byte) string {
+	if digest, ok := d.lookup(name, info); ok {
+		return digest
+	}
+
+	sum := md5.Sum(content)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+
+	d.store(name, info, digest)
+
+	return digest
+}
+
+// fileMD5 is contentMD5 for a file that is streamed rather than read into
+// memory. The file is hashed in a pass of its own before it's sent, so only
+// the first request after it changes reads it twice.
+func (d *digestCache) fileMD5(files fs.FS, name string, info fs.FileInfo) (string, error) {
+	if digest, ok := d.lookup(name, info); ok {
+		return digest, nil
+	}
+
+	file, err := files.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s for its digest: %w", name, err)
+	}
+
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s for its digest: %w", name, err)
+	}
+
+	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	d.store(name, info, digest)
+
+	return digest, nil
+}
+
+// lookup returns the cached digest of the file name, provided it still has
+// the modification time and size described by info.
+func (d *digestCache) lookup(name string, info fs.FileInfo) (string, bool) {
+	d.mu.Lock()
+	entry, ok := d.entries[name]
+	d.mu.Unlock()
+
+	if !ok || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		return "", false
+	}
+
+	return entry.digest, true
+}
+
+func (d *digestCache) store(name string, info fs.FileInfo, digest string) {
+	d.mu.Lock()
+	d.entries[name] = digestEntry{modTime: info.ModTime(), size: info.Size(), digest: digest}
+	d.mu.Unlock()
+}
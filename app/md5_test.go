@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+func md5Of(content string) string {
+	sum := md5.Sum([]byte(content))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestContentMD5(t *testing.T) {
+	tests := map[string]string{
+		"small":    "hello",
+		"streamed": strings.Repeat("streamed content ", 4*maxSingleWrite/17),
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.digests = newDigestCache()
+			writeFile(t, cfg, "a.bin", content)
+
+			// the second response comes from the cache
+			for i := 0; i < 2; i++ {
+				response := roundTrip(t, cfg, "GET /files/a.bin HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+				if response.body != content {
+					t.Fatalf("got %d bytes of body, want %d", len(response.body), len(content))
+				}
+
+				if got := response.header.Get("Content-MD5"); got != md5Of(content) {
+					t.Errorf("request %d: got Content-MD5 %q, want %q", i+1, got, md5Of(content))
+				}
+			}
+		})
+	}
+}
+
+func TestContentMD5FollowsChanges(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.digests = newDigestCache()
+
+	for _, content := range []string{strings.Repeat("a", 2*maxSingleWrite), strings.Repeat("b", 3*maxSingleWrite)} {
+		writeFile(t, cfg, "a.bin", content)
+
+		response := roundTrip(t, cfg, "GET /files/a.bin HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		if got := response.header.Get("Content-MD5"); got != md5Of(content) {
+			t.Errorf("got Content-MD5 %q, want %q", got, md5Of(content))
+		}
+	}
+}
+
+func TestStreamableWithDigests(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.digests = newDigestCache()
+	fileName := writeFile(t, cfg, "a.bin", strings.Repeat("x", 2*maxSingleWrite))
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+
+	c, _ := newConnection(&scriptedConn{}, cfg)
+
+	request := &request{headers: map[string]string{}}
+	if !c.streamable(request, info, "application/octet-stream") {
+		t.Errorf("file not streamed with -content-md5")
+	}
+}
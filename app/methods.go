@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// implementedMethods are the methods the server can handle, in the order
+// they're listed in Allow headers.
+var implementedMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "TRACE"}
+
+// parseMethods parses the comma separated allowlist of -methods.
+func parseMethods(value string) ([]string, error) {
+	enabled := make(map[string]bool)
+
+	for _, method := range strings.Split(value, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			continue
+		}
+
+		if !methodIn(implementedMethods, method) {
+			return nil, fmt.Errorf("unsupported method %q, expected some of %s", method, strings.Join(implementedMethods, ","))
+		}
+
+		enabled[method] = true
+	}
+
+	if len(enabled) == 0 {
+		return nil, fmt.Errorf("at least one method must be enabled")
+	}
+
+	var methods []string
+	for _, method := range implementedMethods {
+		if enabled[method] {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods, nil
+}
+
+func methodIn(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// methodEnabled reports whether requests using method are served.
+func (c *connection) methodEnabled(method string) bool {
+	if method == "TRACE" && c.disableTrace {
+		return false
+	}
+
+	return methodIn(c.methods, method)
+}
+
+// enabledMethods filters methods down to the ones that are enabled.
+func (c *connection) enabledMethods(methods []string) []string {
+	var enabled []string
+
+	for _, method := range methods {
+		if c.methodEnabled(method) {
+			enabled = append(enabled, method)
+		}
+	}
+
+	return enabled
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// requestsPerConnectionBuckets are the upper bounds of the histogram of how
+// many requests a connection served before it was closed.
+var requestsPerConnectionBuckets = []int{1, 2, 5, 10, 25, 50, 100}
+
+// metrics holds the counters exposed on /metrics in the Prometheus text
+// format.
+type metrics struct {
+	mu sync.Mutex
+
+	// requestsPerConnection counts the closed connections that served at
+	// most the matching bucket's number of requests, the last element
+	// counting the ones above every bucket
+	requestsPerConnection []int64
+	connections           int64
+	requests              int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{requestsPerConnection: make([]int64, len(requestsPerConnectionBuckets)+1)}
+}
+
+// recordConnection adds a closed connection that served requests to the
+// histogram.
+func (m *metrics) recordConnection(requests int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket := len(requestsPerConnectionBuckets)
+	for i, bound := range requestsPerConnectionBuckets {
+		if requests <= bound {
+			bucket = i
+			break
+		}
+	}
+
+	m.requestsPerConnection[bucket]++
+	m.connections++
+	m.requests += int64(requests)
+}
+
+// render formats the metrics for a scrape.
+func (m *metrics) render() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var builder strings.Builder
+
+	builder.WriteString("# HELP http_requests_per_connection Requests served on a connection before it was closed.\n")
+	builder.WriteString("# TYPE http_requests_per_connection histogram\n")
+
+	// buckets are cumulative in the exposition format
+	var cumulative int64
+	for i, bound := range requestsPerConnectionBuckets {
+		cumulative += m.requestsPerConnection[i]
+		fmt.Fprintf(&builder, "http_requests_per_connection_bucket{le=\"%d\"} %d\n", bound, cumulative)
+	}
+
+	fmt.Fprintf(&builder, "http_requests_per_connection_bucket{le=\"+Inf\"} %d\n", m.connections)
+	fmt.Fprintf(&builder, "http_requests_per_connection_sum %d\n", m.requests)
+	fmt.Fprintf(&builder, "http_requests_per_connection_count %d\n", m.connections)
+
+	return builder.String()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errInvalidFileName = errors.New("invalid file name")
+	errQuotaExceeded   = errors.New("upload exceeds the storage quota")
+)
+
+type storedFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// multipartBoundary returns the boundary of a multipart/form-data request
+// body. The boundary is empty when the body is multipart without one, ok is
+// false when it isn't multipart at all.
+func multipartBoundary(request *request) (boundary string, ok bool) {
+	mediaType, params, err := mime.ParseMediaType(request.headers["Content-Type"])
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", false
+	}
+
+	return params["boundary"], true
+}
+
+// partFileName returns the file name given for part, or "" for form fields
+// that aren't files. The name has to be a single path element, names that
+// would place the file anywhere but the target directory fail with
+// errInvalidFileName.
+func partFileName(part *multipart.Part) (string, error) {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed part: %v", errMalformedRequest, err)
+	}
+
+	name, ok := params["filename"]
+	if !ok {
+		return "", nil
+	}
+
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return "", fmt.Errorf("%w: %q", errInvalidFileName, name)
+	}
+
+	return name, nil
+}
+
+// handleMultipartUpload stores every file part of a multipart/form-data POST
+// in the directory at requestPath under filesDir, each under the file name it
+// was sent with. Other form fields are skipped. It answers 201 with the names
+// and sizes of the stored files as JSON.
+func (c *connection) handleMultipartUpload(ctx context.Context, request *request, requestPath string, boundary string) error {
+	if boundary == "" {
+		return newHTTPError(bad_request, "missing multipart boundary")
+	}
+
+	dir := c.filePath(requestPath)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return newHTTPError(not_found, "upload directory not found")
+	}
+
+	stored := []storedFile{}
+	reader := multipart.NewReader(request.body, boundary)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		var name string
+		if err == nil {
+			name, err = partFileName(part)
+		}
+
+		if err == nil && name == "" {
+			logger.debugf("ignoring form field %q of multipart upload", part.FormName())
+			continue
+		}
+
+		var size int64
+		if err == nil {
+			size, err = c.storeFile(filepath.Join(dir, name), part)
+		}
+
+		if err != nil {
+			return c.failMultipartUpload(err)
+		}
+
+		stored = append(stored, storedFile{Name: name, Size: size})
+	}
+
+	summary, err := json.Marshal(struct {
+		Files []storedFile `json:"files"`
+	}{stored})
+	if err != nil {
+		return fmt.Errorf("failed to encode multipart upload summary: %w", err)
+	}
+
+	headers := []string{"Content-Type: application/json"}
+	if err := c.send(ctx, buildResponse(created, &headers, string(summary))); err != nil {
+		return fmt.Errorf("failed to send CREATED response for multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// failMultipartUpload returns the error response for a multipart upload that
+// failed with err. Files stored from earlier parts are kept.
+func (c *connection) failMultipartUpload(err error) error {
+	// a body that ends before its closing boundary is malformed, even when the
+	// client went away, there's no telling the two apart
+	truncated := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if !truncated && isClientAbort(err) {
+		return fmt.Errorf("failed to receive multipart upload: %w", err)
+	}
+
+	// errors that aren't about the file system are the body's fault
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+
+	responseType := bad_request
+	message := "malformed multipart body"
+
+	switch {
+	case errors.Is(err, errInvalidFileName):
+		message = err.Error()
+	case errors.Is(err, errRequestTooLarge):
+		responseType = payload_too_large
+		message = "request exceeds the maximum request size"
+	case errors.Is(err, errQuotaExceeded):
+		responseType = insufficient_storage
+		message = "upload exceeds the storage quota"
+	case errors.Is(err, os.ErrPermission):
+		responseType = forbidden
+		message = "directory is not writable"
+	case errors.As(err, &pathErr) || errors.As(err, &linkErr):
+		responseType = internal_server_error
+		message = "unable to write file"
+	}
+
+	return &httpError{status: responseType, message: message, err: fmt.Errorf("failed to store multipart upload: %w", err)}
+}
+
+// storeFile writes body to fileName through a temporary file that is renamed
+// into place once complete, charging it to the quota. It returns the size of
+// the stored file.
+func (c *connection) storeFile(fileName string, body io.Reader) (int64, error) {
+	var existingSize int64
+	if info, err := os.Stat(fileName); err == nil {
+		existingSize = info.Size()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+
+	tempName := file.Name()
+	renamed := false
+
+	defer func() {
+		file.Close()
+		if !renamed {
+			os.Remove(tempName)
+		}
+	}()
+
+	allowed := int64(-1)
+	if c.quota != nil {
+		allowed = c.quota.available() + existingSize
+		body = io.LimitReader(body, allowed+1)
+	}
+
+	written, err := io.Copy(file, body)
+	if err != nil {
+		return 0, err
+	}
+
+	if allowed >= 0 && written > allowed {
+		return 0, errQuotaExceeded
+	}
+
+	// match the permissions os.Create would have given the file
+	err = file.Chmod(0644)
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = os.Rename(tempName, fileName)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	renamed = true
+
+	if c.quota != nil {
+		c.quota.adjust(written - existingSize)
+	}
+
+	return written, nil
+}
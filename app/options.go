@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// allowedMethods lists the enabled methods the resource at path supports, or
+// nil when there's no such resource. The asterisk-form "*" stands for the server as a
+// whole.
+func (c *connection) allowedMethods(path string) []string {
+	var methods []string
+
+	pathSplit := strings.Split(path, "/")
+
+	switch {
+	case path == "*":
+		methods = implementedMethods
+	case len(pathSplit) == 2 && pathSplit[1] == "":
+		methods = []string{"GET", "HEAD", "OPTIONS"}
+	case pathSplit[1] == "files" && c.zipPath != "":
+		methods = []string{"GET", "HEAD", "OPTIONS"}
+	case pathSplit[1] == "files":
+		methods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}
+	case path == "/favicon.ico" && c.favicon != nil:
+		methods = []string{"GET", "HEAD", "OPTIONS"}
+	case path == "/debug/connections" && c.registry != nil:
+		methods = []string{"GET", "HEAD", "OPTIONS"}
+	case pathSplit[1] == "ws":
+		// the handshake has to be a GET, a HEAD can't upgrade the connection
+		methods = []string{"GET", "OPTIONS"}
+	case pathSplit[1] == "echo" || pathSplit[1] == "user-agent" || pathSplit[1] == "livez" ||
+		pathSplit[1] == "readyz" || pathSplit[1] == "headers" || pathSplit[1] == "delay" ||
+		pathSplit[1] == "archive" || pathSplit[1] == "metrics" || pathSplit[1] == "proxy" ||
+		pathSplit[1] == "version":
+		methods = []string{"GET", "HEAD", "OPTIONS"}
+	default:
+		return nil
+	}
+
+	if path != "*" {
+		methods = append(methods, "TRACE")
+	}
+
+	return c.enabledMethods(methods)
+}
+
+// handleOptions describes what the target of the request supports. Files also
+// advertise whether byte ranges may be requested.
+func (c *connection) handleOptions(ctx context.Context, request *request) error {
+	path := strings.Split(request.protocol, " ")[1]
+
+	methods := c.allowedMethods(path)
+	if methods == nil {
+		return newHTTPError(not_found, "")
+	}
+
+	headers := []string{"Allow: " + strings.Join(methods, ", ")}
+
+	if strings.HasPrefix(path, "/files/") {
+		acceptRanges := "Accept-Ranges: bytes"
+		if !c.ranges {
+			acceptRanges = "Accept-Ranges: none"
+		}
+
+		headers = append(headers, acceptRanges)
+	}
+
+	if err := c.send(ctx, buildResponse(ok, &headers, "")); err != nil {
+		return fmt.Errorf("failed to send OK response for OPTIONS request: %w", err)
+	}
+
+	return nil
+}
+
+// handleServerOptions answers "OPTIONS *" with the methods the server
+// supports anywhere.
+func (c *connection) handleServerOptions(ctx context.Context) error {
+	headers := []string{"Allow: " + strings.Join(c.allowedMethods("*"), ", ")}
+	if err := c.send(ctx, buildResponse(no_content, &headers, "")); err != nil {
+		return fmt.Errorf("failed to send NO CONTENT response for OPTIONS * request: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func allowed(response testResponse) []string {
+	return strings.Split(response.header.Get("Allow"), ", ")
+}
+
+func TestServerOptions(t *testing.T) {
+	output, err := exchange(t, testConfig(t), "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /echo/after HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if err != nil {
+		t.Fatalf("handle failed: %v", err)
+	}
+
+	responses := parseResponses(t, output, "OPTIONS", "GET")
+
+	if responses[0].status != 204 {
+		t.Errorf("got status %d, want 204", responses[0].status)
+	}
+
+	if got := strings.Join(allowed(responses[0]), ","); got != strings.Join(implementedMethods, ",") {
+		t.Errorf("got Allow %q, want every implemented method", got)
+	}
+
+	if responses[1].body != "after" {
+		t.Errorf("got %q after OPTIONS *, want \"after\"", responses[1].body)
+	}
+}
+
+func TestServerOptionsOnlyEnabledMethods(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.methods = []string{"GET", "HEAD", "OPTIONS"}
+
+	response := roundTrip(t, cfg, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if got := response.header.Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("got Allow %q, want GET, HEAD, OPTIONS", got)
+	}
+}
+
+func TestPathOptions(t *testing.T) {
+	tests := []struct {
+		path   string
+		status int
+		allow  string
+	}{
+		{"/", 200, "GET, HEAD, OPTIONS, TRACE"},
+		{"/echo/abc", 200, "GET, HEAD, OPTIONS, TRACE"},
+		{"/files/a.txt", 200, "GET, HEAD, POST, PUT, DELETE, OPTIONS, TRACE"},
+		{"/ws", 200, "GET, OPTIONS, TRACE"},
+		{"/nowhere", 404, ""},
+	}
+
+	for _, test := range tests {
+		response := roundTrip(t, testConfig(t), "OPTIONS "+test.path+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		if response.status != test.status || response.header.Get("Allow") != test.allow {
+			t.Errorf("OPTIONS %s: got %d Allow %q, want %d %q", test.path, response.status, response.header.Get("Allow"), test.status, test.allow)
+		}
+	}
+}
+
+func TestPathOptionsFilesAdvertiseRanges(t *testing.T) {
+	cfg := testConfig(t)
+
+	response := roundTrip(t, cfg, "OPTIONS /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("got Accept-Ranges %q, want bytes", response.header.Get("Accept-Ranges"))
+	}
+
+	cfg.ranges = false
+
+	response = roundTrip(t, cfg, "OPTIONS /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.header.Get("Accept-Ranges") != "none" {
+		t.Errorf("got Accept-Ranges %q with ranges disabled, want none", response.header.Get("Accept-Ranges"))
+	}
+}
+
+func TestPathOptionsWithTraceDisabled(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.disableTrace = true
+
+	response := roundTrip(t, cfg, "OPTIONS /echo/x HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if got := response.header.Get("Allow"); strings.Contains(got, "TRACE") {
+		t.Errorf("got Allow %q with TRACE disabled", got)
+	}
+}
+
+func TestPathOptionsFavicon(t *testing.T) {
+	cfg := testConfig(t)
+
+	if response := roundTrip(t, cfg, "OPTIONS /favicon.ico HTTP/1.1\r\nHost: localhost\r\n\r\n"); response.status != 404 {
+		t.Errorf("got %d without -favicon, want 404", response.status)
+	}
+
+	cfg.favicon = []byte("icon")
+
+	response := roundTrip(t, cfg, "OPTIONS /favicon.ico HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 200 || response.header.Get("Allow") != "GET, HEAD, OPTIONS, TRACE" {
+		t.Errorf("got %d Allow %q, want 200 GET, HEAD, OPTIONS, TRACE", response.status, response.header.Get("Allow"))
+	}
+}
+
+func TestPathOptionsDebugConnections(t *testing.T) {
+	cfg := testConfig(t)
+
+	if response := roundTrip(t, cfg, "OPTIONS /debug/connections HTTP/1.1\r\nHost: localhost\r\n\r\n"); response.status != 404 {
+		t.Errorf("got %d without -debug, want 404", response.status)
+	}
+
+	cfg.registry = newConnRegistry()
+
+	response := roundTrip(t, cfg, "OPTIONS /debug/connections HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 200 || response.header.Get("Allow") != "GET, HEAD, OPTIONS, TRACE" {
+		t.Errorf("got %d Allow %q, want 200 GET, HEAD, OPTIONS, TRACE", response.status, response.header.Get("Allow"))
+	}
+
+	if response := roundTrip(t, cfg, "OPTIONS /debug/other HTTP/1.1\r\nHost: localhost\r\n\r\n"); response.status != 404 {
+		t.Errorf("got %d for /debug/other, want 404", response.status)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader hands out at most size bytes per read, pausing before each.
+type slowReader struct {
+	reader io.Reader
+	size   int
+	pause  time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.pause)
+
+	if len(p) > r.size {
+		p = p[:r.size]
+	}
+
+	return r.reader.Read(p)
+}
+
+func TestPipelinedSlowFileBeforeFastEcho(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.chunkSize = 4 * 1024
+	content := strings.Repeat("0123456789abcdef", 16*maxSingleWrite/16)
+	writeFile(t, cfg, "large.bin", content)
+
+	client, reader := dial(t, cfg)
+
+	go io.WriteString(client, "GET /files/large.bin HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /echo/fast HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	// the file is read slowly so the echo has every chance to jump ahead
+	reader.Reset(&slowReader{reader: client, size: 4 * 1024, pause: time.Millisecond})
+
+	response := readResponse(t, reader, "GET")
+	if response.status != 200 || response.body != content {
+		t.Fatalf("got %d with %d bytes of body first, want 200 with the whole file", response.status, len(response.body))
+	}
+
+	if response = readResponse(t, reader, "GET"); response.body != "fast" {
+		t.Errorf("got %q second, want \"fast\"", response.body)
+	}
+}
+
+func TestPipelinedDelayBeforeEcho(t *testing.T) {
+	output, _ := exchange(t, testConfig(t), "GET /delay/0.1 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /echo/fast HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /delay/0 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	responses := parseResponses(t, output, "GET", "GET", "GET")
+
+	if responses[1].body != "fast" {
+		t.Errorf("got %q second, want \"fast\"", responses[1].body)
+	}
+
+	for _, i := range []int{0, 2} {
+		if responses[i].status != 200 || responses[i].body != "" {
+			t.Errorf("response %d: got %d %q, want the delay", i+1, responses[i].status, responses[i].body)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "mime"
+
+// postProcessor transforms the body of a response before it's sent, for
+// example to minify HTML or inject a script. process is given the
+// Content-Type of the response and returns the body to send in place of
+// body. Only responses of one of mediaTypes are passed to it, everything else
+// is left alone and can still be streamed.
+type postProcessor struct {
+	mediaTypes map[string]bool
+	process    func(contentType string, body []byte) []byte
+}
+
+// postProcessors are applied to complete 200 bodies of GET and HEAD requests
+// in the order they were registered.
+var postProcessors []postProcessor
+
+// registerPostProcessor adds process to the end of the post-processors, for
+// responses of the given media types such as "text/html". It's meant to be
+// called from init functions, before the server starts.
+func registerPostProcessor(mediaTypes []string, process func(contentType string, body []byte) []byte) {
+	p := postProcessor{mediaTypes: make(map[string]bool), process: process}
+	for _, mediaType := range mediaTypes {
+		p.mediaTypes[mediaType] = true
+	}
+
+	postProcessors = append(postProcessors, p)
+}
+
+// postProcessed reports whether any post-processor applies to responses of
+// mediaType, which then need their whole body in memory.
+func postProcessed(mediaType string) bool {
+	for _, p := range postProcessors {
+		if p.mediaTypes[mediaType] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postProcess runs body through every post-processor registered for its
+// Content-Type, returning whether any were applied.
+func postProcess(headers []string, body []byte) ([]byte, bool) {
+	if len(postProcessors) == 0 {
+		return body, false
+	}
+
+	contentType := headerValue(headers, "Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	applied := false
+	for _, p := range postProcessors {
+		if p.mediaTypes[mediaType] {
+			body = p.process(contentType, body)
+			applied = true
+		}
+	}
+
+	return body, applied
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withPostProcessor registers an HTML post-processor for the duration of the
+// test.
+func withPostProcessor(t *testing.T) {
+	t.Helper()
+
+	registered := postProcessors
+	t.Cleanup(func() { postProcessors = registered })
+
+	registerPostProcessor([]string{"text/html"}, func(contentType string, body []byte) []byte {
+		return bytes.Replace(body, []byte("</body>"), []byte("<script></script></body>"), 1)
+	})
+}
+
+func TestPostProcessorAppliesToItsMediaTypes(t *testing.T) {
+	withPostProcessor(t)
+
+	cfg := testConfig(t)
+	writeFile(t, cfg, "index.html", "<html><body></body></html>")
+	writeFile(t, cfg, "page.txt", "<body></body>")
+
+	response := roundTrip(t, cfg, "GET /files/index.html HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if want := "<html><body><script></script></body></html>"; response.body != want {
+		t.Errorf("got HTML body %q, want %q", response.body, want)
+	}
+
+	if response.header.Get("ETag") != "" {
+		t.Errorf("processed body still tagged %q", response.header.Get("ETag"))
+	}
+
+	response = roundTrip(t, cfg, "GET /files/page.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.body != "<body></body>" || response.header.Get("ETag") == "" {
+		t.Errorf("text file was post-processed: %q", response.body)
+	}
+}
+
+func TestPostProcessorLeavesOtherFilesStreamed(t *testing.T) {
+	withPostProcessor(t)
+
+	cfg := testConfig(t)
+	fileName := writeFile(t, cfg, "large.bin", strings.Repeat("</body>", maxSingleWrite))
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+
+	c, _ := newConnection(&scriptedConn{}, cfg)
+	request := &request{headers: map[string]string{}}
+
+	if !c.streamable(request, info, "application/octet-stream") {
+		t.Errorf("binary file buffered for an HTML post-processor")
+	}
+
+	if c.streamable(request, info, "text/html") {
+		t.Errorf("HTML file streamed past its post-processor")
+	}
+
+	response := roundTrip(t, cfg, "GET /files/large.bin HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.body != strings.Repeat("</body>", maxSingleWrite) {
+		t.Errorf("binary file changed on the way out")
+	}
+}
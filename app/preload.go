@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// preloadCache holds the content of the -preload files in memory so they're
+// served without touching the disk. An entry is only used while the file
+// still has the modification time and size it had when it was read, a file
+// that changed is served from disk and its entry refreshed.
+type preloadCache struct {
+	mu      sync.Mutex
+	entries map[string]preloadEntry
+}
+
+type preloadEntry struct {
+	modTime time.Time
+	size    int64
+	content []byte
+}
+
+// loadPreload reads the comma separated file names of -preload, relative to
+// the root of files, into a new cache. Every name has to be a regular file.
+func loadPreload(files fs.FS, value string) (*preloadCache, error) {
+	cache := &preloadCache{entries: make(map[string]preloadEntry)}
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		name = fsPath(name)
+
+		info, err := fs.Stat(files, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to preload %s: %w", name, err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("failed to preload %s: not a regular file", name)
+		}
+
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to preload %s: %w", name, err)
+		}
+
+		cache.entries[name] = preloadEntry{modTime: info.ModTime(), size: info.Size(), content: content}
+	}
+
+	return cache, nil
+}
+
+// lookup returns the preloaded content of the file name described by info,
+// or false when it isn't preloaded or has changed since.
+func (p *preloadCache) lookup(name string, info fs.FileInfo) ([]byte, bool) {
+	if p == nil {
+		return nil, false
+	}
+
+	p.mu.Lock()
+	entry, ok := p.entries[name]
+	p.mu.Unlock()
+
+	if !ok || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		return nil, false
+	}
+
+	return entry.content, true
+}
+
+// refresh replaces the entry of a preloaded file that changed with content,
+// just read from disk. Files that weren't preloaded are left out.
+func (p *preloadCache) refresh(name string, info fs.FileInfo, content []byte) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.entries[name]; ok {
+		p.entries[name] = preloadEntry{modTime: info.ModTime(), size: info.Size(), content: content}
+	}
+}
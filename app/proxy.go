@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hopByHopHeaders only apply to a single connection, so they're neither
+// forwarded to the upstream nor relayed back to the client.
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// upstreamClient relays redirects to the client instead of following them.
+var upstreamClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// parseUpstream validates the value of -upstream.
+func parseUpstream(value string) (*url.URL, error) {
+	upstream, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL, got %q", value)
+	}
+
+	return upstream, nil
+}
+
+// connWriter sends everything written to it to the client as is.
+type connWriter struct {
+	ctx context.Context
+	c   *connection
+}
+
+func (w *connWriter) Write(p []byte) (int, error) {
+	if err := w.c.send(w.ctx, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// handleProxy forwards a request under /proxy to the same path under the
+// upstream and relays the response. An unreachable upstream is answered with
+// 502 and one that doesn't respond within upstreamTimeout with 504.
+func (c *connection) handleProxy(ctx context.Context, request *request) error {
+	if c.upstream == nil {
+		return newHTTPError(not_found, "")
+	}
+
+	requestLine := strings.Split(request.protocol, " ")
+	method := requestLine[0]
+	target := strings.TrimSuffix(c.upstream.String(), "/") + strings.TrimPrefix(requestLine[1], "/proxy")
+
+	// upstreamTimeout covers connecting and waiting for the response head,
+	// the body is relayed for as long as the request may take
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	timer := time.AfterFunc(c.upstreamTimeout, cancel)
+
+	upstreamRequest, err := http.NewRequestWithContext(upstreamCtx, method, target, http.NoBody)
+	if err != nil {
+		return &httpError{status: bad_request, message: "invalid proxy path", err: err}
+	}
+
+	for name, value := range request.headers {
+		if !hopByHopHeaders[http.CanonicalHeaderKey(name)] && !strings.EqualFold(name, "Host") {
+			upstreamRequest.Header.Set(name, value)
+		}
+	}
+
+	// add the peer to the chain of addresses the request went through
+	peer, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		peer = c.conn.RemoteAddr().String()
+	}
+
+	if forwardedFor, ok := request.headers["X-Forwarded-For"]; ok {
+		peer = forwardedFor + ", " + peer
+	}
+
+	upstreamRequest.Header.Set("X-Forwarded-For", peer)
+
+	response, err := upstreamClient.Do(upstreamRequest)
+
+	// the timer may have fired just as the head arrived, the body can't be
+	// read then
+	timedOut := !timer.Stop()
+	if err == nil && timedOut {
+		response.Body.Close()
+		err = context.DeadlineExceeded
+	}
+
+	if err != nil {
+		var netErr net.Error
+		responseType := bad_gateway
+		if timedOut || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			responseType = gateway_timeout
+		}
+
+		return &httpError{status: responseType, message: "upstream request failed", err: fmt.Errorf("proxy request to %s failed: %w", target, err)}
+	}
+
+	defer response.Body.Close()
+
+	if redirect := response.Header.Get("X-Accel-Redirect"); redirect != "" {
+		return c.internalRedirect(ctx, request, redirect)
+	}
+
+	names := make([]string, 0, len(response.Header))
+	for name := range response.Header {
+		if !hopByHopHeaders[name] && name != "Content-Length" {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	var headers []string
+	for _, name := range names {
+		for _, value := range response.Header[name] {
+			headers = append(headers, name+": "+value)
+		}
+	}
+
+	code := response.StatusCode
+	noBody := (code >= 100 && code < 200) || code == 204 || code == 304
+	bodyless := method == "HEAD" || noBody
+
+	// relay a body of unknown length chunked rather than buffering it. net/http
+	// reports a length of 0 for a status that can't have a body, which isn't
+	// something to tell the client: a 204 has no Content-Length and a 304 only
+	// the one the upstream sent, describing the representation it validated.
+	chunked := response.ContentLength < 0 && !bodyless
+	switch {
+	case noBody:
+		if length := response.Header.Get("Content-Length"); code == 304 && length != "" {
+			headers = append(headers, "Content-Length: "+length)
+		}
+	case chunked:
+		headers = append(headers, "Transfer-Encoding: chunked")
+	case response.ContentLength >= 0:
+		headers = append(headers, fmt.Sprintf("Content-Length: %d", response.ContentLength))
+	}
+
+	if err := c.send(ctx, relayedHead(statusLine(response.StatusCode), headers)); err != nil {
+		return fmt.Errorf("failed to send proxied response headers: %w", err)
+	}
+
+	if bodyless {
+		return nil
+	}
+
+	if !chunked {
+		if _, err := ctxCopy(ctx, &connWriter{ctx: ctx, c: c}, response.Body, c.chunkSize); err != nil {
+			return fmt.Errorf("failed to relay proxied response body: %w", err)
+		}
+
+		return nil
+	}
+
+	writer := newChunkedWriter(ctx, c)
+	if _, err := ctxCopy(ctx, writer, response.Body, c.chunkSize); err != nil {
+		return fmt.Errorf("failed to relay proxied response body: %w", err)
+	}
+
+	return writer.Close()
+}
+
+// relayedHead builds the status line and headers of a proxied response. Unlike
+// buildResponse it keeps every header the upstream repeated, so several
+// WWW-Authenticate challenges reach the client as they were sent.
+func relayedHead(status string, headers []string) []byte {
+	var builder strings.Builder
+
+	builder.WriteString(status + "\r\n")
+	for _, header := range relayHeaders(headers, defaultHeaders) {
+		builder.WriteString(header + "\r\n")
+	}
+	builder.WriteString("\r\n")
+
+	return []byte(builder.String())
+}
+
+// internalRedirect serves the file at target in place of an upstream response
+// carrying X-Accel-Redirect. This lets an upstream decide whether a client
+// may have a file without the client learning where it's stored or the file
+// passing through the upstream. Only paths under /files/ can be redirected to.
+func (c *connection) internalRedirect(ctx context.Context, request *request, target string) error {
+	if !strings.HasPrefix(target, "/files/") || strings.ContainsAny(target, " \r\n") {
+		return &httpError{status: bad_gateway, message: "invalid internal redirect", err: fmt.Errorf("upstream sent an invalid X-Accel-Redirect %q", target)}
+	}
+
+	requestLine := strings.Split(request.protocol, " ")
+
+	// the client's headers still apply, so ranges and conditional requests
+	// work against the file
+	redirected := *request
+	redirected.protocol = requestLine[0] + " " + target + " " + requestLine[2]
+	redirected.body = http.NoBody
+
+	return c.handleGet(ctx, &redirected)
+}
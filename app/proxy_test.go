@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyConfig returns a config proxying /proxy to an upstream served by
+// handler.
+func proxyConfig(t *testing.T, handler http.HandlerFunc) *config {
+	t.Helper()
+
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	cfg := testConfig(t)
+
+	var err error
+	if cfg.upstream, err = parseUpstream(upstream.URL); err != nil {
+		t.Fatalf("failed to parse upstream URL: %v", err)
+	}
+
+	return cfg
+}
+
+func TestProxyRelaysRepeatedHeaders(t *testing.T) {
+	cfg := proxyConfig(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("WWW-Authenticate", `Basic realm="a"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="b"`)
+		w.Header().Add("X-Upstream", "one")
+		w.Header().Add("X-Upstream", "two")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	response := roundTrip(t, cfg, "GET /proxy/private HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 401 {
+		t.Fatalf("got status %d, want 401", response.status)
+	}
+
+	if got, want := response.header.Values("WWW-Authenticate"), []string{`Basic realm="a"`, `Bearer realm="b"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got WWW-Authenticate %q, want %q", got, want)
+	}
+
+	if got := response.header.Values("X-Upstream"); len(got) != 2 {
+		t.Errorf("got X-Upstream %q, want both values", got)
+	}
+}
+
+func TestProxyTimeoutOnlyCoversResponseHead(t *testing.T) {
+	cfg := proxyConfig(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("head "))
+		w.(http.Flusher).Flush()
+
+		// the body takes longer than the upstream timeout
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("and body"))
+	})
+	cfg.upstreamTimeout = 100 * time.Millisecond
+
+	response := roundTrip(t, cfg, "GET /proxy/slow-body HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 200 || response.body != "head and body" {
+		t.Errorf("got %d %q, want 200 \"head and body\"", response.status, response.body)
+	}
+}
+
+func TestProxySlowHeadTimesOut(t *testing.T) {
+	cfg := proxyConfig(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	cfg.upstreamTimeout = 50 * time.Millisecond
+
+	response := roundTrip(t, cfg, "GET /proxy/slow-head HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 504 {
+		t.Errorf("got status %d, want 504", response.status)
+	}
+}
+
+func TestProxyUnreachableUpstream(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	cfg := testConfig(t)
+	cfg.upstream, _ = parseUpstream(upstream.URL)
+
+	response := roundTrip(t, cfg, "GET /proxy/x HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 502 {
+		t.Errorf("got status %d, want 502", response.status)
+	}
+}
+
+func TestProxyBodylessStatusesWithoutFraming(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		cfg := proxyConfig(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			w.WriteHeader(status)
+		})
+
+		output, _ := exchange(t, cfg, "GET /proxy/resource HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		head, _, _ := strings.Cut(output, "\r\n\r\n")
+		if strings.Contains(head, "Content-Length") || strings.Contains(head, "Transfer-Encoding") {
+			t.Errorf("upstream %d relayed with framing headers:\n%s", status, head)
+		}
+
+		responses := parseResponses(t, output, "GET", "GET")
+
+		if responses[0].status != status || responses[0].header.Get("ETag") != `"v1"` {
+			t.Errorf("got %d with ETag %q, want upstream %d", responses[0].status, responses[0].header.Get("ETag"), status)
+		}
+
+		if responses[1].body != "next" {
+			t.Errorf("got %q after the %d, want \"next\"", responses[1].body, status)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// quota caps the total size of the files stored under filesDir. The current
+// usage is computed once at startup and then kept up to date as uploads and
+// deletes go through the server, so requests don't have to rescan the
+// directory.
+type quota struct {
+	mu    sync.Mutex
+	dir   string
+	limit int64
+	used  int64
+}
+
+func newQuota(dir string, limit int64) (*quota, error) {
+	q := &quota{dir: dir, limit: limit}
+
+	if err := q.rescan(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// rescan recomputes the usage from disk, used when a change is too involved
+// to account for incrementally.
+func (q *quota) rescan() error {
+	var used int64
+
+	err := filepath.Walk(q.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			used += info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.used = used
+	q.mu.Unlock()
+
+	return nil
+}
+
+// available returns the number of bytes that can still be stored.
+func (q *quota) available() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.used >= q.limit {
+		return 0
+	}
+
+	return q.limit - q.used
+}
+
+// fits reports whether growing the stored files by delta bytes stays within
+// the quota.
+func (q *quota) fits(delta int64) bool {
+	return delta <= q.available()
+}
+
+// adjust records that the stored files grew (or shrank) by delta bytes.
+func (q *quota) adjust(delta int64) {
+	q.mu.Lock()
+	q.used += delta
+	q.mu.Unlock()
+}
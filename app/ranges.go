@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInvalidRange         = errors.New("invalid range")
+	errUnsupportedRangeUnit = errors.New("unsupported range unit")
+	errTooManyRanges        = errors.New("too many ranges")
+)
+
+// maxRanges is the most ranges served from a single request once overlapping
+// and adjacent ones are merged, a request for more gets the whole resource.
+const maxRanges = 16
+
+// byteRange is an inclusive range of byte offsets within a resource.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// parseRange parses the value of a Range header against a resource of the
+// given size. Ranges that start past the end of the resource are dropped and
+// errInvalidRange is returned when the header is malformed or none of the
+// requested ranges can be satisfied. Units other than bytes fail with
+// errUnsupportedRangeUnit.
+//
+// Overlapping and adjacent ranges are merged, so no byte is sent twice and
+// the ranges come back in ascending order. More than maxRanges of them fail
+// with errTooManyRanges.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, spec, found := strings.Cut(header, "=")
+	if !found {
+		return nil, errInvalidRange
+	}
+
+	if strings.TrimSpace(unit) != "bytes" {
+		return nil, errUnsupportedRangeUnit
+	}
+
+	var ranges []byteRange
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last, found := strings.Cut(part, "-")
+		if !found {
+			return nil, errInvalidRange
+		}
+
+		var r byteRange
+
+		if first == "" {
+			// suffix range, the last n bytes of the resource
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errInvalidRange
+			}
+
+			if n > size {
+				n = size
+			}
+
+			r = byteRange{start: size - n, end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+
+				if end > size-1 {
+					end = size - 1
+				}
+			}
+
+			r = byteRange{start: start, end: end}
+		}
+
+		if r.start >= size || r.length() <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errInvalidRange
+	}
+
+	ranges = mergeRanges(ranges)
+	if len(ranges) > maxRanges {
+		return nil, errTooManyRanges
+	}
+
+	return ranges, nil
+}
+
+// mergeRanges sorts ranges by their start and combines the ones that overlap
+// or touch.
+func mergeRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.start > last.end+1 {
+			merged = append(merged, r)
+			continue
+		}
+
+		if r.end > last.end {
+			last.end = r.end
+		}
+	}
+
+	return merged
+}
+
+// buildMultipartRanges assembles a multipart/byteranges body holding each of
+// the requested ranges of content. It returns the body along with the
+// boundary used to separate the parts.
+func buildMultipartRanges(content []byte, ranges []byteRange, contentType string) ([]byte, string, error) {
+	boundaryBytes := make([]byte, 16)
+	if _, err := rand.Read(boundaryBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate multipart boundary: %w", err)
+	}
+
+	boundary := hex.EncodeToString(boundaryBytes)
+	size := int64(len(content))
+
+	var body bytes.Buffer
+
+	for _, r := range ranges {
+		if r.end >= size {
+			return nil, "", fmt.Errorf("range %d-%d exceeds content of %d bytes", r.start, r.end, size)
+		}
+
+		body.WriteString("--" + boundary + "\r\n")
+		body.WriteString("Content-Type: " + contentType + "\r\n")
+		body.WriteString("Content-Range: " + r.contentRange(size) + "\r\n")
+		body.WriteString("\r\n")
+		body.Write(content[r.start : r.end+1])
+		body.WriteString("\r\n")
+	}
+
+	body.WriteString("--" + boundary + "--\r\n")
+
+	return body.Bytes(), boundary, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{"bytes=0-9", []byteRange{{0, 9}}, nil},
+		{"bytes=90-", []byteRange{{90, 99}}, nil},
+		{"bytes=-10", []byteRange{{90, 99}}, nil},
+		{"bytes=95-200", []byteRange{{95, 99}}, nil},
+		{"bytes=0-9, 20-29", []byteRange{{0, 9}, {20, 29}}, nil},
+		{"bytes=20-29,0-9", []byteRange{{0, 9}, {20, 29}}, nil},
+		{"bytes=0-9,5-14", []byteRange{{0, 14}}, nil},
+		{"bytes=0-9,10-19", []byteRange{{0, 19}}, nil},
+		{"bytes=0-50,10-20", []byteRange{{0, 50}}, nil},
+		{"bytes=0-0,0-0,0-0", []byteRange{{0, 0}}, nil},
+		{"bytes=-5,90-", []byteRange{{90, 99}}, nil},
+		{"bytes=100-", nil, errInvalidRange},
+		{"bytes=9-0", nil, errInvalidRange},
+		{"bytes", nil, errInvalidRange},
+		{"items=0-9", nil, errUnsupportedRangeUnit},
+	}
+
+	for _, test := range tests {
+		got, err := parseRange(test.header, size)
+		if !errors.Is(err, test.err) || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseRange(%q) = %v, %v, want %v, %v", test.header, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestParseRangeCapsMergedRanges(t *testing.T) {
+	var parts []string
+	for i := 0; i < maxRanges+1; i++ {
+		parts = append(parts, fmt.Sprintf("%d-%d", i*10, i*10+1))
+	}
+
+	if _, err := parseRange("bytes="+strings.Join(parts, ","), 1000); !errors.Is(err, errTooManyRanges) {
+		t.Errorf("got %v for %d ranges, want errTooManyRanges", err, maxRanges+1)
+	}
+
+	// the same count of ranges is fine when they merge into few
+	parts = parts[:0]
+	for i := 0; i < 100; i++ {
+		parts = append(parts, fmt.Sprintf("%d-%d", i, i))
+	}
+
+	if ranges, err := parseRange("bytes="+strings.Join(parts, ","), 1000); err != nil || len(ranges) != 1 {
+		t.Errorf("got %v, %v for 100 adjacent ranges, want one range", ranges, err)
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "digits.txt", "0123456789")
+
+	get := func(rangeHeader string) testResponse {
+		return roundTrip(t, cfg, "GET /files/digits.txt HTTP/1.1\r\nHost: localhost\r\nRange: "+rangeHeader+"\r\n\r\n")
+	}
+
+	response := get("bytes=2-4")
+	if response.status != 206 || response.body != "234" || response.header.Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("single range: got %d %q %q", response.status, response.body, response.header.Get("Content-Range"))
+	}
+
+	response = get("bytes=0-3,2-5")
+	if response.status != 206 || response.body != "012345" {
+		t.Errorf("overlapping ranges: got %d %q, want one part \"012345\"", response.status, response.body)
+	}
+
+	response = get("bytes=20-")
+	if response.status != 416 || response.header.Get("Content-Range") != "bytes */10" {
+		t.Errorf("unsatisfiable range: got %d %q", response.status, response.header.Get("Content-Range"))
+	}
+
+	var parts []string
+	for i := 0; i < 10; i += 2 {
+		parts = append(parts, fmt.Sprintf("%d-%d", i, i))
+	}
+
+	response = get("bytes=" + strings.Join(parts, ","))
+	if response.status != 206 {
+		t.Fatalf("multiple ranges: got status %d, want 206", response.status)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(response.header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("multiple ranges: got Content-Type %q", response.header.Get("Content-Type"))
+	}
+
+	reader := multipart.NewReader(strings.NewReader(response.body), params["boundary"])
+
+	var got []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		got = append(got, part.Header.Get("Content-Range"))
+	}
+
+	if len(got) != 5 || got[0] != "bytes 0-0/10" || got[4] != "bytes 8-8/10" {
+		t.Errorf("multiple ranges: got parts %v", got)
+	}
+}
+
+func TestTooManyRangesServesWholeFile(t *testing.T) {
+	cfg := testConfig(t)
+	content := strings.Repeat("x", 100)
+	writeFile(t, cfg, "many.txt", content)
+
+	var parts []string
+	for i := 0; i < 2*maxRanges; i++ {
+		parts = append(parts, fmt.Sprintf("%d-%d", i*3, i*3))
+	}
+
+	response := roundTrip(t, cfg, "GET /files/many.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes="+strings.Join(parts, ",")+"\r\n\r\n")
+
+	if response.status != 200 || response.body != content {
+		t.Errorf("got %d with %d bytes, want 200 with the whole file", response.status, len(response.body))
+	}
+}
+
+func TestUnsupportedRangeUnitServesWholeFile(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "items.txt", "0123456789")
+
+	for _, unit := range []string{"items", "lines", "none"} {
+		response := roundTrip(t, cfg, "GET /files/items.txt HTTP/1.1\r\nHost: localhost\r\nRange: "+unit+"=0-9\r\n\r\n")
+
+		if response.status != 200 || response.body != "0123456789" {
+			t.Errorf("Range in %s: got %d %q, want 200 with the whole file", unit, response.status, response.body)
+		}
+
+		if got := response.header.Get("Content-Range"); got != "" {
+			t.Errorf("Range in %s: got Content-Range %q on a full response", unit, got)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// receivedHeaders sends headers with a request to /headers and returns what
+// the server parsed out of them.
+func receivedHeaders(t *testing.T, headers string) map[string]string {
+	t.Helper()
+
+	response := roundTrip(t, testConfig(t), "GET /headers HTTP/1.1\r\n"+headers+"\r\n")
+	if response.status != 200 {
+		t.Fatalf("got status %d, want 200", response.status)
+	}
+
+	var parsed map[string]string
+	if err := json.Unmarshal([]byte(response.body), &parsed); err != nil {
+		t.Fatalf("failed to decode %q: %v", response.body, err)
+	}
+
+	return parsed
+}
+
+func TestHeaderWhitespaceAroundValue(t *testing.T) {
+	tests := map[string]string{
+		"no space":           "X-Test:value\r\n",
+		"one space":          "X-Test: value\r\n",
+		"two spaces":         "X-Test:  value\r\n",
+		"tab":                "X-Test:\tvalue\r\n",
+		"trailing space":     "X-Test: value  \r\n",
+		"trailing tab":       "X-Test: value\t\r\n",
+		"lowercase no space": "x-test:value\r\n",
+	}
+
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := receivedHeaders(t, header)["X-Test"]; got != "value" {
+				t.Errorf("got X-Test %q, want \"value\"", got)
+			}
+		})
+	}
+}
+
+func TestHeaderValueKeepsColonsAndInnerSpaces(t *testing.T) {
+	headers := receivedHeaders(t, "Host:localhost:4221\r\nX-Test: a  b: c\r\nX-Empty:\r\n")
+
+	if headers["Host"] != "localhost:4221" {
+		t.Errorf("got Host %q, want \"localhost:4221\"", headers["Host"])
+	}
+
+	if headers["X-Test"] != "a  b: c" {
+		t.Errorf("got X-Test %q, want \"a  b: c\"", headers["X-Test"])
+	}
+
+	if value, ok := headers["X-Empty"]; !ok || value != "" {
+		t.Errorf("got X-Empty %q, %v, want an empty value", value, ok)
+	}
+}
+
+func TestInvalidHeaderLinesRejected(t *testing.T) {
+	for name, header := range map[string]string{
+		"no colon":           "X-Test value\r\n",
+		"empty name":         ": value\r\n",
+		"space before colon": "X-Test : value\r\n",
+		"space in name":      "X Test: value\r\n",
+		"folded line":        "X-Test: a\r\n continued\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			response := roundTrip(t, testConfig(t), "GET /headers HTTP/1.1\r\nHost: localhost\r\n"+header+"\r\n")
+
+			if response.status != 400 || !response.close {
+				t.Errorf("got %d close=%v, want 400 with Connection: close", response.status, response.close)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMalformedRequestLineClosesConnection(t *testing.T) {
+	for _, line := range []string{
+		"GET /echo/a",
+		"GET  /echo/a HTTP/1.1",
+		"GET /echo/a HTTP/1.1 extra",
+		"GET echo/a HTTP/1.1",
+		"GET * HTTP/1.1",
+		"GET /echo/a FTP/1.0",
+		" /echo/a HTTP/1.1",
+	} {
+		t.Run(line, func(t *testing.T) {
+			// the request after the bad one is never answered
+			output, _ := exchange(t, testConfig(t), line+"\r\nHost: localhost\r\n\r\n"+
+				"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+			response := parseResponses(t, output, "GET")[0]
+
+			if response.status != 400 {
+				t.Errorf("got status %d, want 400", response.status)
+			}
+
+			if !response.close {
+				t.Errorf("400 without Connection: close")
+			}
+		})
+	}
+}
+
+func TestValidRequestLine(t *testing.T) {
+	tests := map[string]bool{
+		"GET / HTTP/1.1":         true,
+		"HEAD /files/a HTTP/1.0": true,
+		"OPTIONS * HTTP/1.1":     true,
+		"DELETE * HTTP/1.1":      false,
+		"GET http://x/ HTTP/1.1": false,
+		"GET / HTTP/1.1 ":        false,
+		"GET /":                  false,
+		"":                       false,
+	}
+
+	for line, want := range tests {
+		if got := validRequestLine(line); got != want {
+			t.Errorf("validRequestLine(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
+
+func TestRequestLineTooLong(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.maxRequestLine = 64
+
+	response := roundTrip(t, cfg, "GET /echo/"+strings.Repeat("a", 100)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 414 {
+		t.Errorf("got status %d, want 414", response.status)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	errInvalidContentRange = errors.New("invalid content range")
+	errUploadTotalChanged  = errors.New("total size differs from earlier parts of the upload")
+)
+
+// parseContentRange parses the "bytes start-end/total" value of the
+// Content-Range header of an upload. Unlike in a response, the total has to
+// be known.
+func parseContentRange(header string) (byteRange, int64, error) {
+	unit, spec, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || unit != "bytes" {
+		return byteRange{}, 0, errInvalidContentRange
+	}
+
+	span, totalValue, found := strings.Cut(spec, "/")
+	if !found {
+		return byteRange{}, 0, errInvalidContentRange
+	}
+
+	first, last, found := strings.Cut(span, "-")
+	if !found {
+		return byteRange{}, 0, errInvalidContentRange
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, 0, errInvalidContentRange
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return byteRange{}, 0, errInvalidContentRange
+	}
+
+	total, err := strconv.ParseInt(totalValue, 10, 64)
+	if err != nil || end >= total {
+		return byteRange{}, 0, errInvalidContentRange
+	}
+
+	return byteRange{start: start, end: end}, total, nil
+}
+
+// partialUpload is the state of a file uploaded in parts.
+type partialUpload struct {
+	total int64
+
+	// received holds the parts stored so far, sorted and merged so that no
+	// two of them overlap or touch
+	received []byteRange
+}
+
+// receivedPrefix returns how many bytes from the start of the file have been
+// received without a gap.
+func (u *partialUpload) receivedPrefix() int64 {
+	if len(u.received) == 0 || u.received[0].start != 0 {
+		return 0
+	}
+
+	return u.received[0].end + 1
+}
+
+func (u *partialUpload) add(r byteRange) {
+	merged := append(u.received, r)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].start < merged[j].start })
+
+	u.received = merged[:1]
+	for _, next := range merged[1:] {
+		current := &u.received[len(u.received)-1]
+		if next.start > current.end+1 {
+			u.received = append(u.received, next)
+			continue
+		}
+
+		if next.end > current.end {
+			current.end = next.end
+		}
+	}
+}
+
+// uploadTracker remembers which parts of resumable uploads have arrived. It
+// only lives in memory, so uploads interrupted by a restart start over.
+type uploadTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*partialUpload
+}
+
+func newUploadTracker() *uploadTracker {
+	return &uploadTracker{uploads: make(map[string]*partialUpload)}
+}
+
+// record notes that r of the upload to fileName, which is total bytes long,
+// has been stored. It returns the number of bytes received from the start of
+// the file, and whether the upload is complete, in which case it's forgotten.
+func (t *uploadTracker) record(fileName string, r byteRange, total int64) (int64, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	upload, ok := t.uploads[fileName]
+	if !ok {
+		upload = &partialUpload{total: total}
+		t.uploads[fileName] = upload
+	}
+
+	if upload.total != total {
+		return upload.receivedPrefix(), false, errUploadTotalChanged
+	}
+
+	upload.add(r)
+
+	received := upload.receivedPrefix()
+	if received < total {
+		return received, false, nil
+	}
+
+	delete(t.uploads, fileName)
+
+	return received, true, nil
+}
+
+// reset forgets every upload in progress.
+func (t *uploadTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.uploads = make(map[string]*partialUpload)
+}
+
+// offsetWriter writes to file sequentially from offset.
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+
+	return n, err
+}
+
+// partialName is where the parts of an upload to fileName are collected until
+// all of them have arrived.
+func partialName(fileName string) string {
+	return filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".upload")
+}
+
+// handleRangeUpload stores the part of a file described by the Content-Range
+// header of a POST or PUT. Parts are written at their offset into a partial
+// file next to fileName, which is renamed into place once every byte up to
+// the total has arrived, so readers only ever see complete files.
+//
+// Until then each part is answered with 308 and a Range header of the bytes
+// received so far from the start of the file, the client resumes from there.
+// The part completing the upload gets the answer a plain upload would.
+func (c *connection) handleRangeUpload(ctx context.Context, request *request, fileName string, existed bool, existingSize int64) error {
+	method := strings.Split(request.protocol, " ")[0]
+
+	r, total, err := parseContentRange(request.headers["Content-Range"])
+	if err == nil && request.contentLength >= 0 && request.contentLength != r.length() {
+		err = fmt.Errorf("%w: body of %d bytes for a range of %d", errInvalidContentRange, request.contentLength, r.length())
+	}
+
+	if err != nil {
+		return &httpError{status: bad_request, message: "invalid Content-Range", err: err}
+	}
+
+	if c.quota != nil && !c.quota.fits(total-existingSize) {
+		return newHTTPError(insufficient_storage, "upload exceeds the storage quota")
+	}
+
+	partName := partialName(fileName)
+
+	file, err := os.OpenFile(partName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		err = fmt.Errorf("failed to open partial upload at %s: %w", partName, err)
+		if errors.Is(err, fs.ErrPermission) {
+			return &httpError{status: forbidden, message: "directory is not writable", err: err}
+		}
+
+		return &httpError{status: internal_server_error, message: "unable to create file", err: err}
+	}
+
+	defer file.Close()
+
+	written, err := io.Copy(&offsetWriter{file: file, offset: r.start}, io.LimitReader(request.body, r.length()))
+	if err == nil && written != r.length() {
+		err = fmt.Errorf("%w: body ended after %d of %d bytes", errMalformedRequest, written, r.length())
+	}
+
+	if err != nil {
+		if isClientAbort(err) {
+			return fmt.Errorf("failed to receive body for %s: %w", fileName, err)
+		}
+
+		return bodyError(fmt.Errorf("failed to write partial upload at %s: %w", partName, err), "unable to write file")
+	}
+
+	received, complete, err := c.uploads.record(fileName, r, total)
+	if errors.Is(err, errUploadTotalChanged) {
+		return newHTTPError(conflict, err.Error())
+	}
+
+	if !complete {
+		var headers []string
+		if received > 0 {
+			headers = append(headers, fmt.Sprintf("Range: bytes=0-%d", received-1))
+		}
+
+		if err := c.send(ctx, buildResponse(resume_incomplete, &headers, "")); err != nil {
+			return fmt.Errorf("failed to send RESUME INCOMPLETE response for %s request: %w", method, err)
+		}
+
+		return nil
+	}
+
+	// a partial file left behind by an earlier upload may be longer
+	err = file.Truncate(total)
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = os.Rename(partName, fileName)
+	}
+
+	if err != nil {
+		return &httpError{status: internal_server_error, message: "unable to write file", err: fmt.Errorf("failed to store file at %s: %w", fileName, err)}
+	}
+
+	if c.quota != nil {
+		c.quota.adjust(total - existingSize)
+	}
+
+	responseType := created
+	if method == "PUT" && existed {
+		responseType = ok
+	}
+
+	if err := c.send(ctx, buildResponse(responseType, nil, "")); err != nil {
+		return fmt.Errorf("failed to send response for %s request: %w", method, err)
+	}
+
+	return nil
+}
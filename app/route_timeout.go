@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// routeTimeout gives the requests whose path is prefix or lies under it their
+// own request timeout in place of -timeout.
+type routeTimeout struct {
+	prefix  string
+	timeout time.Duration
+}
+
+// routeTimeouts holds the -route-timeout overrides, given as prefix=duration
+// once per route and kept longest prefix first.
+type routeTimeouts []routeTimeout
+
+func (r *routeTimeouts) String() string {
+	if r == nil {
+		return ""
+	}
+
+	var overrides []string
+	for _, route := range *r {
+		overrides = append(overrides, route.prefix+"="+route.timeout.String())
+	}
+
+	return strings.Join(overrides, ",")
+}
+
+func (r *routeTimeouts) Set(value string) error {
+	prefix, timeoutValue, found := strings.Cut(value, "=")
+	if !found || !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("expected a route timeout like /archive=2m, got %q", value)
+	}
+
+	timeout, err := time.ParseDuration(timeoutValue)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid timeout for route %s: %q", prefix, timeoutValue)
+	}
+
+	*r = append(*r, routeTimeout{prefix: prefix, timeout: timeout})
+	sort.SliceStable(*r, func(i, j int) bool { return len((*r)[i].prefix) > len((*r)[j].prefix) })
+
+	return nil
+}
+
+// lookup returns the timeout of the longest route prefix matching path, or
+// false when no override matches.
+func (r routeTimeouts) lookup(path string) (time.Duration, bool) {
+	path, _, _ = strings.Cut(path, "?")
+
+	for _, route := range r {
+		if underPrefix(path, route.prefix) {
+			return route.timeout, true
+		}
+	}
+
+	return 0, false
+}
+
+// underPrefix reports whether path is prefix or one of the paths below it.
+// Prefixes match whole segments, /delay doesn't match /delayed.
+func underPrefix(path string, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
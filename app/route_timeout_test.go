@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouteTimeoutMatchesWholeSegments(t *testing.T) {
+	var routes routeTimeouts
+	for _, value := range []string{"/delay=1s", "/files/big/=2s", "/files/big/huge=3s"} {
+		if err := routes.Set(value); err != nil {
+			t.Fatalf("Set(%q) failed: %v", value, err)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		timeout time.Duration
+	}{
+		{"/delay", time.Second},
+		{"/delay/5", time.Second},
+		{"/delay?x=1", time.Second},
+		{"/delayed", 0},
+		{"/delay-long/5", 0},
+		{"/files/big/a.iso", 2 * time.Second},
+		{"/files/big", 0},
+		{"/files/big/huge", 3 * time.Second},
+		{"/files/big/huge/part", 3 * time.Second},
+		{"/files/big/hugely", 2 * time.Second},
+		{"/echo", 0},
+	}
+
+	for _, test := range tests {
+		timeout, ok := routes.lookup(test.path)
+		if !ok {
+			timeout = 0
+		}
+
+		if timeout != test.timeout {
+			t.Errorf("lookup(%q) = %v, want %v", test.path, timeout, test.timeout)
+		}
+	}
+}
+
+func TestRouteTimeoutRejectsInvalidValues(t *testing.T) {
+	for _, value := range []string{"delay=1s", "/delay", "/delay=soon", "/delay=0s", "/delay=-1s"} {
+		var routes routeTimeouts
+		if err := routes.Set(value); err == nil {
+			t.Errorf("Set(%q) accepted", value)
+		}
+	}
+}
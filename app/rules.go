@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// responseRule is a canned response from the -rules file, sent for requests
+// whose method and path match instead of handling them.
+type responseRule struct {
+	// Method matches any method when empty
+	Method     string            `json:"method"`
+	PathPrefix string            `json:"path_prefix"`
+	Status     int               `json:"status"`
+	Body       string            `json:"body"`
+	Headers    map[string]string `json:"headers"`
+}
+
+// loadRules reads the ordered list of rules in the JSON file at path.
+// Unknown fields are an error so that typos don't go unnoticed.
+func loadRules(path string) ([]responseRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var rules []responseRule
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+
+	for i, rule := range rules {
+		if rule.Status < 200 || rule.Status > 599 {
+			return nil, fmt.Errorf("rule %d in %s: status %d is not a final status", i+1, path, rule.Status)
+		}
+
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("rule %d in %s: path_prefix %q doesn't start with /", i+1, path, rule.PathPrefix)
+		}
+	}
+
+	return rules, nil
+}
+
+// matchRule returns the first rule matching method and path. Like every GET
+// the server handles, a GET rule also answers HEAD.
+func (cfg *config) matchRule(method string, path string) (responseRule, bool) {
+	for _, rule := range cfg.rules {
+		matchesMethod := rule.Method == "" || strings.EqualFold(rule.Method, method) ||
+			(method == "HEAD" && strings.EqualFold(rule.Method, "GET"))
+
+		if matchesMethod && strings.HasPrefix(path, rule.PathPrefix) {
+			return rule, true
+		}
+	}
+
+	return responseRule{}, false
+}
+
+// sendRule answers request with the canned response of rule.
+func (c *connection) sendRule(ctx context.Context, request *request, rule responseRule) error {
+	names := make([]string, 0, len(rule.Headers))
+	for name := range rule.Headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	headers := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		headers = append(headers, name+": "+rule.Headers[name])
+	}
+
+	// 204 and 304 never have a body, so whatever the rule says there's
+	// nothing for the framing headers to describe. 1xx statuses are refused by
+	// loadRules already.
+	body := rule.Body
+	if rule.Status == 204 || rule.Status == 304 {
+		removeHeader(&headers, "Content-Length")
+		removeHeader(&headers, "Transfer-Encoding")
+		body = ""
+	} else if strings.Split(request.protocol, " ")[0] == "HEAD" {
+		// HEAD gets the length of the body it would have had
+		setHeader(&headers, "Content-Length", fmt.Sprint(len(body)))
+		body = ""
+	}
+
+	if err := c.send(ctx, buildResponse(statusLine(rule.Status), &headers, body)); err != nil {
+		return fmt.Errorf("failed to send response of rule for %s: %w", rule.PathPrefix, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleWithoutBodyStatus(t *testing.T) {
+	for _, status := range []int{204, 304} {
+		cfg := testConfig(t)
+		cfg.rules = []responseRule{{
+			PathPrefix: "/mock",
+			Status:     status,
+			Body:       "ignored",
+			Headers:    map[string]string{"Content-Length": "7", "X-Rule": "yes"},
+		}}
+
+		// the pipelined request must not be read as the body of the first
+		output, err := exchange(t, cfg, "GET /mock/a HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		if err != nil {
+			t.Fatalf("handle failed: %v", err)
+		}
+
+		head, _, _ := strings.Cut(output, "\r\n\r\n")
+		if strings.Contains(head, "Content-Length") || strings.Contains(output, "ignored") {
+			t.Errorf("%d rule sent framing or a body:\n%s", status, output)
+		}
+
+		responses := parseResponses(t, output, "GET", "GET")
+
+		if responses[0].status != status || responses[0].header.Get("X-Rule") != "yes" {
+			t.Errorf("got status %d with X-Rule %q, want %d with the rule's headers", responses[0].status, responses[0].header.Get("X-Rule"), status)
+		}
+
+		if responses[1].body != "next" {
+			t.Errorf("got %q after the %d rule, want \"next\"", responses[1].body, status)
+		}
+	}
+}
+
+func TestRuleMatching(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.rules = []responseRule{
+		{Method: "POST", PathPrefix: "/echo", Status: 503, Body: "down"},
+		{Method: "get", PathPrefix: "/echo/teapot", Status: 418, Body: "short and stout"},
+	}
+
+	response := roundTrip(t, cfg, "GET /echo/teapot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 418 || response.body != "short and stout" {
+		t.Errorf("got %d %q, want the 418 rule", response.status, response.body)
+	}
+
+	output, _ := exchange(t, cfg, "HEAD /echo/teapot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	response = parseResponses(t, output, "HEAD")[0]
+	if response.status != 418 || response.header.Get("Content-Length") != "15" || response.body != "" {
+		t.Errorf("got HEAD %d with Content-Length %q, want 418 with the length of the body", response.status, response.header.Get("Content-Length"))
+	}
+
+	// unmatched requests are handled as usual
+	response = roundTrip(t, cfg, "GET /echo/other HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 200 || response.body != "other" {
+		t.Errorf("got %d %q, want a normal echo", response.status, response.body)
+	}
+}
+
+func TestLoadRulesRejectsInterimStatus(t *testing.T) {
+	cfg := testConfig(t)
+
+	for _, rules := range []string{
+		`[{"path_prefix": "/a", "status": 101}]`,
+		`[{"path_prefix": "/a", "status": 600}]`,
+		`[{"path_prefix": "a", "status": 200}]`,
+		`[{"path_prefix": "/a", "status": 200, "stauts": 404}]`,
+	} {
+		if _, err := loadRules(writeFile(t, cfg, "rules.json", rules)); err == nil {
+			t.Errorf("loaded %s", rules)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var errConnectionBroken = errors.New("connection broken")
+
+// brokenConn accepts limit bytes and then fails every write.
+type brokenConn struct {
+	scriptedConn
+	limit int
+}
+
+func (c *brokenConn) Write(p []byte) (int, error) {
+	if len(p) > c.limit {
+		n, _ := c.scriptedConn.Write(p[:c.limit])
+		c.limit = 0
+		return n, errConnectionBroken
+	}
+
+	c.limit -= len(p)
+	return c.scriptedConn.Write(p)
+}
+
+func TestSendSurfacesWriteErrors(t *testing.T) {
+	tests := map[string]int{
+		// small enough to sit in the buffer until it's flushed
+		"flush": 100,
+		// large enough to go straight past the buffer
+		"write": 64 * 1024,
+	}
+
+	for name, size := range tests {
+		t.Run(name, func(t *testing.T) {
+			conn := &brokenConn{limit: size / 2}
+
+			c, err := newConnection(conn, testConfig(t))
+			if err != nil {
+				t.Fatalf("failed to create connection: %v", err)
+			}
+
+			err = c.send(context.Background(), []byte(strings.Repeat("x", size)))
+			if !errors.Is(err, errConnectionBroken) {
+				t.Errorf("got error %v, want %v", err, errConnectionBroken)
+			}
+		})
+	}
+}
+
+func TestSendWritesWholeMessage(t *testing.T) {
+	conn := &scriptedConn{}
+
+	c, err := newConnection(conn, testConfig(t))
+	if err != nil {
+		t.Fatalf("failed to create connection: %v", err)
+	}
+
+	message := strings.Repeat("y", 64*1024)
+	if err := c.send(context.Background(), []byte(message)); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	if conn.output.String() != message || c.written != int64(len(message)) {
+		t.Errorf("wrote %d bytes and counted %d, want %d", conn.output.Len(), c.written, len(message))
+	}
+}
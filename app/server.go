@@ -2,28 +2,231 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	ok        = "HTTP/1.1 200 OK"
-	created   = "HTTP/1.1 201 CREATED"
-	not_found = "HTTP/1.1 404 NOT FOUND"
-	timeout   = 5 * time.Second
+	continue_             = "HTTP/1.1 100 CONTINUE"
+	switching_protocols   = "HTTP/1.1 101 SWITCHING PROTOCOLS"
+	early_hints           = "HTTP/1.1 103 EARLY HINTS"
+	ok                    = "HTTP/1.1 200 OK"
+	created               = "HTTP/1.1 201 CREATED"
+	no_content            = "HTTP/1.1 204 NO CONTENT"
+	partial_content       = "HTTP/1.1 206 PARTIAL CONTENT"
+	not_modified          = "HTTP/1.1 304 NOT MODIFIED"
+	resume_incomplete     = "HTTP/1.1 308 RESUME INCOMPLETE"
+	bad_request           = "HTTP/1.1 400 BAD REQUEST"
+	unauthorized          = "HTTP/1.1 401 UNAUTHORIZED"
+	forbidden             = "HTTP/1.1 403 FORBIDDEN"
+	not_found             = "HTTP/1.1 404 NOT FOUND"
+	method_not_allowed    = "HTTP/1.1 405 METHOD NOT ALLOWED"
+	conflict              = "HTTP/1.1 409 CONFLICT"
+	precondition_failed   = "HTTP/1.1 412 PRECONDITION FAILED"
+	payload_too_large     = "HTTP/1.1 413 PAYLOAD TOO LARGE"
+	uri_too_long          = "HTTP/1.1 414 URI TOO LONG"
+	range_not_satisfiable = "HTTP/1.1 416 RANGE NOT SATISFIABLE"
+	expectation_failed    = "HTTP/1.1 417 EXPECTATION FAILED"
+	unprocessable_entity  = "HTTP/1.1 422 UNPROCESSABLE ENTITY"
+	upgrade_required      = "HTTP/1.1 426 UPGRADE REQUIRED"
+	internal_server_error = "HTTP/1.1 500 INTERNAL SERVER ERROR"
+	bad_gateway           = "HTTP/1.1 502 BAD GATEWAY"
+	service_unavailable   = "HTTP/1.1 503 SERVICE UNAVAILABLE"
+	gateway_timeout       = "HTTP/1.1 504 GATEWAY TIMEOUT"
+	version_not_supported = "HTTP/1.1 505 HTTP VERSION NOT SUPPORTED"
+	insufficient_storage  = "HTTP/1.1 507 INSUFFICIENT STORAGE"
+
+	defaultPort           = 4221
+	defaultTimeout        = 5 * time.Second
+	defaultIdleTimeout    = 30 * time.Second
+	defaultMaxRequestLine = 8 * 1024
+	maxDelay              = 60 * time.Second
+	minAcceptBackoff      = 5 * time.Millisecond
+
+	// maxSingleWrite is the largest body sent in one write with its headers
+	maxSingleWrite = 16 * 1024
 )
 
+// statusLines maps status codes to the status lines above.
+var statusLines = make(map[int]string)
+
+func init() {
+	for _, line := range []string{
+		continue_, switching_protocols, early_hints, ok, created, no_content,
+		partial_content, not_modified, resume_incomplete, bad_request, unauthorized,
+		forbidden, not_found, method_not_allowed, conflict, precondition_failed,
+		payload_too_large, uri_too_long, range_not_satisfiable, expectation_failed,
+		unprocessable_entity, upgrade_required, internal_server_error, bad_gateway,
+		service_unavailable, gateway_timeout, version_not_supported,
+		insufficient_storage,
+	} {
+		statusLines[statusCode([]byte(line))] = line
+	}
+}
+
+// statusLine returns the status line for code.
+func statusLine(code int) string {
+	if line, ok := statusLines[code]; ok {
+		return line
+	}
+
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, strings.ToUpper(http.StatusText(code)))
+}
+
+var (
+	errRequestLineTooLong = errors.New("request line too long")
+	errMalformedRequest   = errors.New("malformed request")
+	errRequestTooLarge    = errors.New("request too large")
+	errHTTP2Preface       = errors.New("HTTP/2 connection preface")
+)
+
+// http2Preface is the request line an HTTP/2 client starts the connection
+// with, followed by "\r\nSM\r\n\r\n".
+const http2Preface = "PRI * HTTP/2.0"
+
+// config holds the server wide settings shared by every connection.
+type config struct {
+	filesDir       string
+	timeout        time.Duration
+	idleTimeout    time.Duration
+	maxDuration    time.Duration
+	maxRequestLine int
+	maxRequests    int
+	maxRequestSize int64
+	ranges         bool
+	gzip           bool
+	gzipMinSize    int
+	chunkSize      int
+	emptyAs204     bool
+	requireUA      bool
+	errorFormat    string
+	logBody        bool
+	followSymlinks bool
+	methods        []string
+	indexFiles     []string
+	spaEntry       string
+	trustProxy     bool
+	disableTrace   bool
+	cacheControl   *cacheControlRules
+	earlyHints     earlyHints
+	latency        latencyRange
+	routeTimeouts  routeTimeouts
+	authenticator  Authenticator
+	quota          *quota
+	idempotency    *idempotencyStore
+	uploads        *uploadTracker
+	adminToken     string
+	rules          []responseRule
+	accessLog      accessLogger
+	metrics        *metrics
+	lifecycle      *lifecycle
+
+	// headerTimeout caps the time taken to receive a request's head and
+	// headerLineTimeout the time between two of its lines, both unlimited
+	// beyond timeout when zero
+	headerTimeout     time.Duration
+	headerLineTimeout time.Duration
+
+	// upstream is where requests under /proxy are forwarded to, nil when
+	// proxying is disabled
+	upstream        *url.URL
+	upstreamTimeout time.Duration
+
+	// files is what GET requests under /files are served from, os.DirFS of
+	// filesDir unless the files come from elsewhere. Uploads and deletes
+	// always work on filesDir directly.
+	files fs.FS
+
+	// zipPath is the -zip archive that files reads from, /files is read only
+	// when it's set
+	zipPath string
+
+	// stdinName is the file under /files that serves what was read from stdin
+	// with -serve-stdin, as stdinType when that's set
+	stdinName string
+	stdinType string
+
+	// digests caches the Content-MD5 of files, it's nil unless -content-md5
+	// is set
+	digests *digestCache
+
+	// preloaded holds the -preload files in memory, nil when none are
+	preloaded *preloadCache
+
+	// favicon is served for /favicon.ico, which is a 404 when it's nil
+	favicon []byte
+
+	// registry tracks the open connections for /debug/connections, nil
+	// unless -debug is set
+	registry *connRegistry
+
+	// devFiles is set in dev mode, where caching headers are disabled and
+	// every served file is logged
+	devFiles *servedFiles
+}
+
 type request struct {
 	headers  map[string]string
 	protocol string
-	content  string
+
+	// body streams the request body from the connection. It is bounded by
+	// Content-Length, decodes chunked bodies and is empty when there's none.
+	body io.Reader
+
+	// contentLength is the declared size of body, or -1 when it isn't known
+	// up front because the body is chunked
+	contentLength int64
+
+	// preview records the start of body when -log-body is set
+	preview *previewReader
+
+	// acceptsTrailers is set when the client sent TE: trailers, trailer
+	// fields are only sent to clients that accept them
+	acceptsTrailers bool
+
+	// Deprecated: handlers should stream body instead. content is only
+	// populated once a handler calls readContent.
+	content string
+}
+
+// needsContentLength reports whether a response with the given status line and
+// headers lacks the headers needed to delimit its body.
+func needsContentLength(protocol string, headers *[]string) bool {
+	code := statusCode([]byte(protocol))
+	if (code >= 100 && code < 200) || code == 204 || code == 304 {
+		return false
+	}
+
+	if headers == nil {
+		return true
+	}
+
+	for _, header := range *headers {
+		name, _, _ := strings.Cut(header, ":")
+		if strings.EqualFold(name, "Content-Length") || strings.EqualFold(name, "Transfer-Encoding") {
+			return false
+		}
+	}
+
+	return true
 }
 
 func buildResponse(protocol string, headers *[]string, content string) []byte {
@@ -31,25 +234,129 @@ func buildResponse(protocol string, headers *[]string, content string) []byte {
 
 	builder.WriteString(protocol + "\r\n")
 
+	// interim responses only carry the headers they were built with
+	var lines []string
 	if headers != nil {
-		builder.WriteString(strings.Join(*headers, "\r\n"))
+		lines = *headers
+	}
+
+	if statusCode([]byte(protocol)) >= 200 {
+		lines = mergeHeaders(lines, defaultHeaders)
+	}
+
+	if len(lines) != 0 {
+		builder.WriteString(strings.Join(lines, "\r\n"))
 		builder.WriteString("\r\n")
 	}
 
+	// on a keep-alive connection the client relies on the framing headers to
+	// find the end of the response, so make sure every response has one
+	if needsContentLength(protocol, headers) {
+		builder.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(content)))
+	}
+
 	builder.WriteString("\r\n")
 
 	if len(content) != 0 {
-		builder.WriteString(content + "\r\n")
+		builder.WriteString(content)
 	}
 
 	return []byte(builder.String())
 }
 
 type connection struct {
-	conn     net.Conn
-	reader   *bufio.Reader
-	writer   *bufio.Writer
-	filesDir string
+	*config
+
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	// status and written describe the response to the current request
+	status  int
+	written int64
+
+	// upgraded is set once the connection switched to another protocol
+	upgraded bool
+
+	// served counts the requests handled on this connection
+	served int
+
+	// accepted, traffic and current describe the connection on
+	// /debug/connections, which reads them while it's being served. current
+	// is the request line of the request being served, "" between requests.
+	accepted time.Time
+	traffic  *countingConn
+	current  atomic.Value
+
+	// connectionHeaders are added to the response to the current request to
+	// describe what happens to the connection afterwards
+	connectionHeaders []string
+
+	// expectContinue is set while the current request's client waits for a
+	// 100 Continue before sending the body
+	expectContinue *continueReader
+}
+
+// withHeaders inserts headers into message right after its status line.
+func withHeaders(message []byte, headers []string) []byte {
+	end := bytes.Index(message, []byte("\r\n"))
+	if end == -1 || len(headers) == 0 {
+		return message
+	}
+
+	extra := strings.Join(headers, "\r\n") + "\r\n"
+
+	result := make([]byte, 0, len(message)+len(extra))
+	result = append(result, message[:end+2]...)
+	result = append(result, extra...)
+	result = append(result, message[end+2:]...)
+
+	return result
+}
+
+// readLine reads a single line from the client without the trailing CRLF. At
+// most limit bytes (excluding the line terminator) are buffered; longer lines
+// fail with errTooLong instead of growing the buffer without bound.
+func (c *connection) readLine(limit int, errTooLong error) (string, error) {
+	var line []byte
+
+	for {
+		chunk, err := c.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > limit+2 {
+			return "", errTooLong
+		}
+
+		if err == nil {
+			break
+		}
+
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
+	}
+
+	trimmed := strings.TrimSuffix(string(line), "\r\n")
+	if len(trimmed) > limit {
+		return "", errTooLong
+	}
+
+	return trimmed, nil
+}
+
+// setLineDeadline sets the read deadline for the next line of the request
+// head. With a line timeout each line has to arrive within it, a client that
+// keeps making progress is only stopped by headerDeadline.
+func (c *connection) setLineDeadline(headerDeadline time.Time) {
+	deadline := headerDeadline
+	if c.headerLineTimeout > 0 {
+		if next := time.Now().Add(c.headerLineTimeout); next.Before(deadline) {
+			deadline = next
+		}
+	}
+
+	c.conn.SetReadDeadline(deadline)
 }
 
 func (c *connection) receive(ctx context.Context) (*request, error) {
@@ -58,65 +365,148 @@ func (c *connection) receive(ctx context.Context) (*request, error) {
 		return nil, fmt.Errorf("no deadline set on context")
 	}
 
-	c.conn.SetReadDeadline(deadline)
+	// a slow client may take at most headerTimeout to send the request line
+	// and headers, so it can't hold the connection for the whole request
+	// timeout one byte at a time
+	headerDeadline := deadline
+	if c.headerTimeout > 0 {
+		if capped := time.Now().Add(c.headerTimeout); capped.Before(headerDeadline) {
+			headerDeadline = capped
+		}
+	}
+
+	c.setLineDeadline(headerDeadline)
 
 	headers := make(map[string]string)
 	var request request
 
-	protocolProcessed := false
+	// handle only calls receive once the request has started, so this EOF
+	// cut the request line short
+	requestLine, err := c.readLine(c.maxRequestLine, errRequestLineTooLong)
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: request line ended before its line break", errMalformedRequest)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the rest of the preface is left unread, the connection is closed
+	if requestLine == http2Preface {
+		return nil, errHTTP2Preface
+	}
+
+	request.protocol = requestLine
+
+	// size counts the bytes of the request so far against maxRequestSize
+	size := int64(len(requestLine) + 2)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
+			c.setLineDeadline(headerDeadline)
+
+			// the request line arrived, so a client that stops before the
+			// blank line sent a request that can't be complete
 			lineBytes, err := c.reader.ReadBytes('\n')
+			if err == io.EOF {
+				return nil, fmt.Errorf("%w: request head ended before its blank line", errMalformedRequest)
+			}
+
 			if err != nil {
-				if err == io.EOF {
-					break
-				}
 				return nil, err
 			}
 
-			line := strings.TrimSuffix(string(lineBytes), "\r\n")
-
-			// process protocol
-			if !protocolProcessed {
-				request.protocol = line
-				protocolProcessed = true
-				continue
+			size += int64(len(lineBytes))
+			if c.maxRequestSize > 0 && size > c.maxRequestSize {
+				return nil, errRequestTooLarge
 			}
 
+			line := strings.TrimSuffix(string(lineBytes), "\r\n")
+
 			// process header
 			if len(line) != 0 {
-				headerSplit := strings.Split(line, ": ")
-				headers[headerSplit[0]] = headerSplit[1]
+				// the space after the colon is optional, and any amount of it
+				// isn't part of the value
+				name, value, found := strings.Cut(line, ":")
+				if !found || name == "" || strings.ContainsAny(name, " \t") {
+					return nil, fmt.Errorf("%w: invalid header line %q", errMalformedRequest, line)
+				}
+
+				// header names are case insensitive, they're stored in
+				// canonical form so every lookup sees them however they
+				// were sent
+				name = textproto.CanonicalMIMEHeaderKey(name)
+				value = strings.TrimSpace(value)
+
+				// a request with differing lengths could be framed one way
+				// by a proxy and another by this server, so it's rejected
+				if name == "Content-Length" {
+					if value, err = mergeContentLength(headers[name], value); err != nil {
+						return nil, err
+					}
+				}
+
+				// repeated Transfer-Encoding headers make up one list of
+				// codings, it mustn't look like chunked alone when the
+				// last one is
+				if previous, ok := headers[name]; ok && name == "Transfer-Encoding" {
+					value = previous + ", " + value
+				}
+
+				headers[name] = value
 				continue
 			}
 
 			// set headers
 			request.headers = headers
-			if _, ok := request.headers["Content-Length"]; !ok {
+			request.acceptsTrailers = acceptsTrailers(headers["Te"])
+
+			c.conn.SetReadDeadline(deadline)
+
+			// the body is left on the connection for the handler to stream, the
+			// read deadline stays in place until the handler is done with it
+			if transferEncoding, ok := request.headers["Transfer-Encoding"]; ok {
+				// with both framings a proxy and this server could disagree on
+				// where the body ends, letting a request be smuggled past the proxy
+				if _, ok := request.headers["Content-Length"]; ok {
+					return nil, fmt.Errorf("%w: both Content-Length and Transfer-Encoding are set", errMalformedRequest)
+				}
+
+				if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+					return nil, fmt.Errorf("%w: unsupported transfer encoding %q", errMalformedRequest, transferEncoding)
+				}
+
+				request.body = newChunkedReader(c.reader)
+				if c.maxRequestSize > 0 {
+					request.body = &sizeLimitReader{reader: request.body, remaining: c.maxRequestSize - size}
+				}
+
+				request.contentLength = -1
 				return &request, nil
 			}
 
-			// if content length is not 0, read content
-			contentLength, err := strconv.Atoi(request.headers["Content-Length"])
-			if err != nil {
-				return nil, fmt.Errorf("invalid content length")
+			if _, ok := request.headers["Content-Length"]; !ok {
+				request.body = strings.NewReader("")
+				c.conn.SetReadDeadline(time.Time{})
+				return &request, nil
 			}
 
-			buffer := make([]byte, contentLength)
-			n, err := c.reader.Read(buffer)
-			if err != nil {
-				return nil, err
+			contentLength, err := strconv.ParseInt(request.headers["Content-Length"], 10, 64)
+			if err != nil || contentLength < 0 {
+				return nil, fmt.Errorf("%w: invalid content length", errMalformedRequest)
 			}
 
-			if n != contentLength {
-				return nil, fmt.Errorf("invalid content length")
+			if c.maxRequestSize > 0 && contentLength > c.maxRequestSize-size {
+				return nil, errRequestTooLarge
 			}
 
-			request.content += string(buffer[:])
+			// exactly the declared body is read, anything after it belongs to
+			// the next request on the connection
+			request.body = newFixedLengthReader(c.reader, contentLength)
+			request.contentLength = contentLength
 
 			return &request, nil
 		}
@@ -133,12 +523,46 @@ func (c *connection) send(ctx context.Context, message []byte) error {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
-		_, err := c.writer.Write(message)
+		var status int
+		if c.status == 0 {
+			status = statusCode(message)
+			if status >= 200 {
+				// a client that was never told to send its body may still
+				// do so, the connection can't be reused after that
+				connectionHeaders := c.connectionHeaders
+				if c.expectContinue.awaiting() {
+					connectionHeaders = []string{"Connection: close"}
+				}
+
+				message = withHeaders(message, connectionHeaders)
+
+				if err := c.injectLatency(ctx); err != nil {
+					return err
+				}
+			}
+		}
+
+		n, err := c.writer.Write(message)
+		c.written += int64(n)
+		// interim responses other than a protocol switch are followed by the
+		// final one, which is the status that counts
+		if c.status == 0 && (status >= 200 || status == 101) {
+			c.status = status
+		}
+
 		if err != nil {
-			return fmt.Errorf("unable to send message to client")
+			return fmt.Errorf("unable to send message to client: %w", err)
+		}
+
+		if n != len(message) {
+			return fmt.Errorf("short write to client: buffered %d of %d bytes", n, len(message))
+		}
+
+		if err := c.writer.Flush(); err != nil {
+			return fmt.Errorf("unable to flush message to client: %w", err)
 		}
 
-		return c.writer.Flush()
+		return nil
 	}
 }
 
@@ -147,9 +571,16 @@ func (c *connection) handleGet(ctx context.Context, request *request) error {
 	path := strings.Split(startLine, " ")[1]
 	pathSplit := strings.Split(path, "/")
 
+	// the root has no body, so GET and HEAD both get the bare status line and
+	// a zero Content-Length
 	if len(pathSplit) == 2 && pathSplit[1] == "" {
-		if err := c.send(ctx, buildResponse(ok, nil, "")); err != nil {
-			return fmt.Errorf("failed to send OK response for root request")
+		responseType := ok
+		if c.emptyAs204 {
+			responseType = no_content
+		}
+
+		if err := c.send(ctx, buildResponse(responseType, nil, "")); err != nil {
+			return fmt.Errorf("failed to send OK response for root request: %w", err)
 		}
 
 		return nil
@@ -162,6 +593,9 @@ func (c *connection) handleGet(ctx context.Context, request *request) error {
 		stringContent string
 
 		fileContent []byte
+
+		// fileReader is set instead of fileContent when a file is streamed
+		fileReader io.Reader
 	)
 
 	switch pathSplit[1] {
@@ -174,194 +608,1583 @@ func (c *connection) handleGet(ctx context.Context, request *request) error {
 			contentLength,
 		}
 	case "user-agent":
-		stringContent = request.headers["User-Agent"]
+		// a request without the header answers with an empty body unless
+		// -require-user-agent is set, a header with an empty value always does
+		userAgent, found := request.headers["User-Agent"]
+		if !found && c.requireUA {
+			responseType = bad_request
+			stringContent = "missing User-Agent"
+			break
+		}
+
+		stringContent = userAgent
 		contentType := "Content-Type: text/plain"
 		contentLength := fmt.Sprintf("Content-Length: %d", len(stringContent))
 		headers = []string{
 			contentType,
 			contentLength,
 		}
-	case "files":
-		fileName := c.filesDir + "/" + strings.Join(pathSplit[2:], "/")
+	case "livez":
+		stringContent = "ok"
+		headers = []string{
+			"Content-Type: text/plain",
+			fmt.Sprintf("Content-Length: %d", len(stringContent)),
+		}
+	case "readyz":
+		stringContent = "ready"
+		if !c.lifecycle.ready.Load() {
+			responseType = service_unavailable
+			stringContent = "not ready"
+		}
 
-		fileInfo, err := os.Stat(fileName)
-		if (err != nil && os.IsNotExist(err)) || fileInfo.IsDir() {
+		headers = []string{
+			"Content-Type: text/plain",
+			fmt.Sprintf("Content-Length: %d", len(stringContent)),
+		}
+	case "debug":
+		if c.registry == nil || len(pathSplit) != 3 || pathSplit[2] != "connections" {
 			responseType = not_found
 			break
 		}
+
+		content, err := c.registry.render()
 		if err != nil {
-			return fmt.Errorf("failed to get file info for file name %s", fileName)
+			return err
 		}
 
-		file, err := os.Open(fileName)
+		stringContent = content
+		headers = []string{
+			"Content-Type: application/json",
+			fmt.Sprintf("Content-Length: %d", len(stringContent)),
+		}
+	case "metrics":
+		stringContent = c.metrics.render()
+		headers = []string{
+			"Content-Type: text/plain; version=0.0.4",
+			fmt.Sprintf("Content-Length: %d", len(stringContent)),
+		}
+	case "version":
+		content, err := versionJSON()
 		if err != nil {
-			return fmt.Errorf("failed to open file: %w", err)
+			return fmt.Errorf("failed to encode version: %w", err)
 		}
 
-		defer file.Close()
-
-		contentType := "Content-Type: application/octet-stream"
-		contentLength := fmt.Sprintf("Content-Length: %d", fileInfo.Size())
-
+		stringContent = content
 		headers = []string{
-			contentType,
-			contentLength,
+			"Content-Type: application/json",
+			fmt.Sprintf("Content-Length: %d", len(stringContent)),
+		}
+	case "headers":
+		// maps are encoded with their keys sorted, keeping the output stable
+		encoded, err := json.Marshal(request.headers)
+		if err != nil {
+			return fmt.Errorf("failed to encode request headers: %w", err)
 		}
 
-		reader := bufio.NewReader(file)
+		stringContent = string(encoded)
+		headers = []string{
+			"Content-Type: application/json",
+			fmt.Sprintf("Content-Length: %d", len(stringContent)),
+		}
+	case "delay":
+		seconds, err := strconv.ParseFloat(strings.Join(pathSplit[2:], "/"), 64)
+		if err != nil || !(seconds >= 0) {
+			return newHTTPError(bad_request, "delay must be a non-negative number of seconds")
+		}
 
-		fileContent, err = io.ReadAll(reader)
-		if err != nil {
-			return fmt.Errorf("failed to read file: %w", err)
+		delay := maxDelay
+		if seconds < maxDelay.Seconds() {
+			delay = time.Duration(seconds * float64(time.Second))
 		}
-	default:
-		responseType = not_found
-		if err := c.send(ctx, buildResponse(not_found, nil, "")); err != nil {
-			return fmt.Errorf("failed to send NOT FOUND response for invalid request: %w", err)
+
+		// the request would time out before the delay is over, leaving the
+		// client with a closed connection and no idea why
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return newHTTPError(bad_request, fmt.Sprintf("delay exceeds the request timeout, at most %.1f seconds are left", time.Until(deadline).Seconds()))
 		}
-	}
 
-	httpMessage := buildResponse(
-		responseType,
-		&headers,
-		stringContent,
-	)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
 
-	if err := c.send(
-		ctx,
-		httpMessage,
-	); err != nil {
-		return fmt.Errorf("failed to send http response %v: %w", httpMessage, err)
-	}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("delay of %v aborted: %w", delay, ctx.Err())
+		case <-timer.C:
+		}
+	case "archive":
+		return c.handleArchive(ctx, request, strings.Join(pathSplit[2:], "/"))
+	case "proxy":
+		return c.handleProxy(ctx, request)
+	case "ws":
+		return c.handleWebSocket(ctx, request)
+	case "favicon.ico":
+		if c.favicon == nil || len(pathSplit) != 2 {
+			responseType = not_found
+			break
+		}
 
-	if fileContent != nil {
-		if err := c.send(
-			ctx,
-			fileContent,
-		); err != nil {
-			return fmt.Errorf("failed to send file content: %w", err)
+		fileContent = c.favicon
+		headers = []string{
+			"Content-Type: image/x-icon",
+			fmt.Sprintf("Content-Length: %d", len(fileContent)),
 		}
-	}
+	case "files":
+		name := fsPath(strings.Join(pathSplit[2:], "/"))
+		fileName := filepath.Join(c.filesDir, filepath.FromSlash(name))
 
-	return nil
-}
+		fileInfo, err := fs.Stat(c.files, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to get file info for file name %s: %w", fileName, err)
+		}
 
-func (c *connection) handlePost(ctx context.Context, request *request) error {
-	startLine := request.protocol
-	path := strings.Split(startLine, " ")[1]
-	pathSplit := strings.Split(path, "/")
+		if err != nil {
+			entry, entryInfo, found := c.spaFallback(name)
+			if !found {
+				responseType = not_found
+				break
+			}
 
-	if len(pathSplit) < 3 || pathSplit[1] != "files" {
-		if err := c.send(ctx, buildResponse(not_found, nil, "")); err != nil {
-			return fmt.Errorf("failed to send NOT FOUND response for invalid request")
+			name, fileInfo = entry, entryInfo
+			fileName = filepath.Join(c.filesDir, filepath.FromSlash(name))
 		}
 
-		return nil
-	}
+		if fileInfo.IsDir() {
+			index, indexInfo, found := c.indexFile(name)
+			if !found {
+				responseType = not_found
+				break
+			}
 
-	fileName := c.filesDir + "/" + strings.Join(pathSplit[2:], "/")
+			name, fileInfo = index, indexInfo
+			fileName = filepath.Join(c.filesDir, filepath.FromSlash(name))
+		}
 
-	file, err := os.Create(fileName)
-	if err != nil {
-		return fmt.Errorf("failed to create file at %s: %w", fileName, err)
-	}
+		if err := c.checkSymlinks(name); err != nil {
+			if !errors.Is(err, errSymlink) && !errors.Is(err, errOutsideFilesDir) {
+				return fmt.Errorf("failed to check symlinks of %s: %w", fileName, err)
+			}
 
-	defer file.Close()
+			responseType = forbidden
+			break
+		}
 
-	file.WriteString(request.content)
+		// decide on a 304 from the stat alone, before the file is opened
+		etag := "ETag: " + fileETag(fileInfo)
+		if notModified(request, fileInfo) {
+			responseType = not_modified
+			headers = []string{etag, "Last-Modified: " + lastModified(fileInfo)}
+			if cacheControl, ok := c.cacheControl.lookup(fileName); ok && c.devFiles == nil {
+				headers = append(headers, "Cache-Control: "+cacheControl)
+			}
+			break
+		}
 
-	if err := c.send(
-		ctx,
-		buildResponse(
-			created,
-			nil,
-			"",
-		),
-	); err != nil {
-		return fmt.Errorf("failed to send OK response for POST request")
-	}
+		if strings.Split(startLine, " ")[0] == "GET" {
+			if err := c.sendEarlyHints(ctx, request, name); err != nil {
+				return err
+			}
+		}
 
-	return nil
-}
+		// post-processors are picked by media type, which the extension
+		// decides before any of the file is read
+		mediaType := fileMediaType(fileName)
+		if name == c.stdinName && c.stdinType != "" {
+			mediaType, _, _ = mime.ParseMediaType(c.stdinType)
+		}
 
-func (c *connection) handle() error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		// a preloaded file is served from memory as long as it hasn't changed
+		var sniff []byte
+		if content, ok := c.preloaded.lookup(name, fileInfo); ok {
+			fileContent = content
+			sniff = fileContent
+		} else {
+			file, err := c.files.Open(name)
+			if err != nil {
+				return fmt.Errorf("failed to open file: %w", err)
+			}
 
-	defer cancel()
+			defer file.Close()
 
-	request, err := c.receive(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to receive request: %w", err)
-	}
+			reader := bufio.NewReaderSize(file, c.chunkSize)
 
-	requestVerb := strings.Split(request.protocol, " ")[0]
+			// a plain download is streamed from the file, only responses that
+			// need all of it at once read it into memory
+			if c.streamable(request, fileInfo, mediaType) {
+				sniff, err = reader.Peek(sniffLength)
+				if err != nil {
+					return fmt.Errorf("failed to read file: %w", err)
+				}
 
-	switch requestVerb {
-	case "GET":
-		if err := c.handleGet(ctx, request); err != nil {
-			return fmt.Errorf("failed to handle GET request: %w", err)
-		}
-	case "POST":
-		if err := c.handlePost(ctx, request); err != nil {
-			return fmt.Errorf("failed to handle POST request: %w", err)
+				sniff = trimPartialRune(sniff)
+				fileReader = reader
+			} else {
+				fileContent, err = io.ReadAll(reader)
+				if err != nil {
+					return fmt.Errorf("failed to read file: %w", err)
+				}
+
+				sniff = fileContent
+				c.preloaded.refresh(name, fileInfo, fileContent)
+			}
 		}
-	default:
-		return fmt.Errorf("invalid/unsupported request verb: %s", requestVerb)
-	}
 
-	return nil
-}
+		mimeType := fileContentType(fileName, sniff)
+		if name == c.stdinName && c.stdinType != "" {
+			mimeType = c.stdinType
+		}
+		contentType := "Content-Type: " + mimeType
+		contentLength := fmt.Sprintf("Content-Length: %d", fileInfo.Size())
 
-func (c *connection) close() {
-	c.conn.Close()
-}
+		// advertise whether a client may attempt resumable downloads
+		acceptRanges := "Accept-Ranges: bytes"
+		if !c.ranges {
+			acceptRanges = "Accept-Ranges: none"
+		}
 
-func newConnection(conn net.Conn, filesDir string) (*connection, error) {
-	return &connection{
-		conn:     conn,
-		reader:   bufio.NewReader(conn),
-		writer:   bufio.NewWriter(conn),
-		filesDir: filesDir,
-	}, nil
-}
+		headers = []string{
+			contentType,
+			contentLength,
+			acceptRanges,
+			etag,
+			"Last-Modified: " + lastModified(fileInfo),
+		}
 
-func main() {
-	dirFlag := flag.String("directory", ".", "directory to serve files from")
+		// a streamed file isn't in memory to be hashed
+		if c.digests != nil && fileReader != nil {
+			digest, err := c.digests.fileMD5(c.files, name, fileInfo)
+			if err != nil {
+				return err
+			}
 
-	flag.Parse()
+			headers = append(headers, "Content-MD5: "+digest)
+		} else if c.digests != nil {
+			headers = append(headers, "Content-MD5: "+c.digests.contentMD5(name, fileInfo, fileContent))
+		}
 
-	if err := os.MkdirAll(*dirFlag, 0755); err != nil {
-		fmt.Println("Failed to create directory")
-		os.Exit(1)
-	}
+		if c.devFiles != nil {
+			c.logServedFile(fileName, fileInfo)
+		} else if cacheControl, ok := c.cacheControl.lookup(fileName); ok {
+			headers = append(headers, "Cache-Control: "+cacheControl)
+		}
 
-	l, err := net.Listen("tcp", "localhost:4221")
+		rangeHeader, ok := request.headers["Range"]
+		if !ok || !c.ranges {
+			break
+		}
+
+		// serve the whole file if it changed since the client got its part
+		if ifRange, ok := request.headers["If-Range"]; ok && !ifRangeMatches(ifRange, fileInfo) {
+			break
+		}
+
+		size := int64(len(fileContent))
+
+		// a Range in a unit the server doesn't know is ignored, and so is
+		// one asking for too many pieces to be worth assembling
+		ranges, err := parseRange(rangeHeader, size)
+		if errors.Is(err, errUnsupportedRangeUnit) || errors.Is(err, errTooManyRanges) {
+			break
+		}
+
+		if err != nil {
+			responseType = range_not_satisfiable
+			headers = []string{
+				fmt.Sprintf("Content-Range: bytes */%d", size),
+				"Content-Length: 0",
+				acceptRanges,
+			}
+			fileContent = nil
+			break
+		}
+
+		if len(ranges) == 1 {
+			r := ranges[0]
+			responseType = partial_content
+			headers = []string{
+				contentType,
+				"Content-Range: " + r.contentRange(size),
+				fmt.Sprintf("Content-Length: %d", r.length()),
+				acceptRanges,
+				etag,
+			}
+			fileContent = fileContent[r.start : r.end+1]
+			break
+		}
+
+		// fall back to the full 200 response if the parts can't be assembled
+		body, boundary, err := buildMultipartRanges(fileContent, ranges, mimeType)
+		if err != nil {
+			break
+		}
+
+		responseType = partial_content
+		headers = []string{
+			"Content-Type: multipart/byteranges; boundary=" + boundary,
+			fmt.Sprintf("Content-Length: %d", len(body)),
+			acceptRanges,
+			etag,
+		}
+		fileContent = body
+	default:
+		responseType = not_found
+	}
+
+	if statusCode([]byte(responseType)) >= 400 {
+		return &httpError{status: responseType, message: stringContent, headers: headers}
+	}
+
+	if responseType == ok {
+		content := fileContent
+		if content == nil {
+			content = []byte(stringContent)
+		}
+
+		if processed, applied := postProcess(headers, content); applied {
+			fileContent, stringContent = processed, ""
+			setHeader(&headers, "Content-Length", strconv.Itoa(len(processed)))
+
+			// the digest and tag of the file no longer describe the body
+			removeHeader(&headers, "Content-MD5")
+			removeHeader(&headers, "ETag")
+		}
+	}
+
+	// with -empty-as-204 an empty body is sent as no content, which has no
+	// length or type
+	if c.emptyAs204 && responseType == ok && fileReader == nil && len(fileContent) == 0 && stringContent == "" {
+		responseType = no_content
+		removeHeader(&headers, "Content-Length")
+		removeHeader(&headers, "Content-Type")
+	}
+
+	// only complete bodies are compressed. A range is always served from the
+	// uncompressed file, since offsets into a gzip stream are meaningless to
+	// the client, so 206 responses never carry a Content-Encoding. Bodies that
+	// are small or compressed already aren't worth the effort
+	bodySize, _ := strconv.Atoi(headerValue(headers, "Content-Length"))
+	negotiated := c.gzip && responseType == ok && (pathSplit[1] == "echo" || pathSplit[1] == "files") &&
+		bodySize >= c.gzipMinSize && compressible(headerValue(headers, "Content-Type"))
+
+	// whether the body is compressed depends on the Accept-Encoding of the
+	// request, so caches have to keep the two variants apart
+	if negotiated {
+		headers = append(headers, "Vary: Accept-Encoding")
+	}
+
+	// a streamed file is one the client didn't accept gzip for
+	if negotiated && fileReader == nil && acceptsGzip(request.headers["Accept-Encoding"]) {
+		content := fileContent
+		if content == nil {
+			content = []byte(stringContent)
+		}
+
+		compressed, err := gzipBytes(content)
+		if err != nil {
+			return err
+		}
+
+		fileContent, stringContent = compressed, ""
+		setHeader(&headers, "Content-Encoding", "gzip")
+		setHeader(&headers, "Content-Length", strconv.Itoa(len(compressed)))
+
+		// the digest is of the uncompressed file, not of the bytes sent
+		removeHeader(&headers, "Content-MD5")
+
+		// the compressed bytes differ from the file, so its tag is only a
+		// weak validator for them. If-None-Match compares weakly and still
+		// gets a 304 with it, If-Range and If-Match need a strong tag and
+		// never match, so a client can't resume into the compressed bytes
+		for i, header := range headers {
+			if strings.HasPrefix(header, "ETag: ") {
+				headers[i] = "ETag: W/" + strings.TrimPrefix(header, "ETag: ")
+			}
+		}
+	}
+
+	// HEAD responses carry the same headers as GET, including the Content-Length
+	// of the body that would have been sent, but never the body itself
+	if strings.Split(startLine, " ")[0] == "HEAD" {
+		stringContent = ""
+		fileContent = nil
+		fileReader = nil
+	}
+
+	httpMessage := buildResponse(
+		responseType,
+		&headers,
+		stringContent,
+	)
+
+	// a small file goes out in the same write as the headers, larger ones are
+	// sent on their own rather than copied into the message
+	if len(fileContent) <= maxSingleWrite {
+		httpMessage = append(httpMessage, fileContent...)
+		fileContent = nil
+	}
+
+	if err := c.send(
+		ctx,
+		httpMessage,
+	); err != nil {
+		return fmt.Errorf("failed to send http response %v: %w", httpMessage, err)
+	}
+
+	// a failed write or the end of the request stops copying right there, the
+	// file is closed on return without reading the rest of it
+	if fileReader != nil {
+		if _, err := ctxCopy(ctx, &connWriter{ctx: ctx, c: c}, fileReader, c.chunkSize); err != nil {
+			return fmt.Errorf("failed to stream file content: %w", err)
+		}
+
+		return nil
+	}
+
+	// an empty file has nothing left to send once the headers are out
+	if len(fileContent) != 0 {
+		if err := c.send(
+			ctx,
+			fileContent,
+		); err != nil {
+			return fmt.Errorf("failed to send file content: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *connection) handlePost(ctx context.Context, request *request) error {
+	key := idempotencyKey(request)
+	if key == "" || c.idempotency == nil {
+		return c.handleUpload(ctx, request)
+	}
+
+	path := strings.Split(request.protocol, " ")[1]
+
+	if previous, found := c.idempotency.begin(key, path); found {
+		return c.replayIdempotent(ctx, previous, path)
+	}
+
+	err := c.handleUpload(ctx, request)
+	if err != nil || c.status < 200 || c.status >= 300 {
+		c.idempotency.abandon(key)
+		return err
+	}
+
+	c.idempotency.complete(key, c.status)
+
+	return nil
+}
+
+func (c *connection) handlePut(ctx context.Context, request *request) error {
+	return c.handleUpload(ctx, request)
+}
+
+// filePath maps a slash separated path from a request onto filesDir. The path
+// is cleaned against the root first so ".." elements can't climb above it.
+func (c *connection) filePath(requestPath string) string {
+	return filepath.Join(c.filesDir, filepath.FromSlash(filepath.Clean("/"+requestPath)))
+}
+
+// handleUpload stores the request body under filesDir for POST and PUT. The
+// body is streamed to a temporary file next to the target which is renamed
+// into place once complete, so readers never see a partially written file.
+// POST always answers 201, PUT answers 200 when it replaced an existing file.
+// A multipart/form-data POST stores each of its files in the directory at the
+// path instead.
+func (c *connection) handleUpload(ctx context.Context, request *request) error {
+	startLine := request.protocol
+	requestLine := strings.Split(startLine, " ")
+	method := requestLine[0]
+	pathSplit := strings.Split(requestLine[1], "/")
+
+	if len(pathSplit) < 3 || pathSplit[1] != "files" {
+		return newHTTPError(not_found, "")
+	}
+
+	if err := c.checkWritable(request); err != nil {
+		return err
+	}
+
+	// a form posted by a browser carries its files as parts of the body
+	if boundary, ok := multipartBoundary(request); ok && method == "POST" {
+		if dryRun(request) {
+			return newHTTPError(bad_request, "dry run isn't supported for multipart uploads")
+		}
+
+		return c.handleMultipartUpload(ctx, request, strings.Join(pathSplit[2:], "/"), boundary)
+	}
+
+	fileName := c.filePath(strings.Join(pathSplit[2:], "/"))
+
+	existingInfo, statErr := os.Stat(fileName)
+	existed := statErr == nil
+
+	if dryRun(request) {
+		return c.handleDryRunUpload(ctx, request, fileName, existingInfo)
+	}
+
+	if method == "PUT" && !preconditionsMet(request, existingInfo) {
+		return newHTTPError(precondition_failed, "file was modified")
+	}
+
+	if reason := uploadConflict(fileName, existingInfo); reason != "" {
+		return newHTTPError(conflict, reason)
+	}
+
+	// an overwrite only needs room for the difference in size
+	var existingSize int64
+	if existed {
+		existingSize = existingInfo.Size()
+	}
+
+	if _, ok := request.headers["Content-Range"]; ok {
+		return c.handleRangeUpload(ctx, request, fileName, existed, existingSize)
+	}
+
+	if c.quota != nil && request.contentLength >= 0 && !c.quota.fits(request.contentLength-existingSize) {
+		return newHTTPError(insufficient_storage, "upload exceeds the storage quota")
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		err = fmt.Errorf("failed to create file at %s: %w", fileName, err)
+		if errors.Is(err, fs.ErrPermission) {
+			return &httpError{status: forbidden, message: "directory is not writable", err: err}
+		}
+
+		return &httpError{status: internal_server_error, message: "unable to create file", err: err}
+	}
+
+	tempName := file.Name()
+	renamed := false
+
+	defer func() {
+		file.Close()
+		if !renamed {
+			os.Remove(tempName)
+		}
+	}()
+
+	body := request.body
+
+	// the size of a chunked body isn't known up front, so stop reading one
+	// byte past what the quota allows
+	allowed := int64(-1)
+	if c.quota != nil {
+		allowed = c.quota.available() + existingSize
+		body = io.LimitReader(body, allowed+1)
+	}
+
+	written, err := io.Copy(file, body)
+	if err == nil && allowed >= 0 && written > allowed {
+		return newHTTPError(insufficient_storage, "upload exceeds the storage quota")
+	}
+
+	if err != nil {
+		if isClientAbort(err) {
+			return fmt.Errorf("failed to receive body for %s: %w", fileName, err)
+		}
+
+		return bodyError(fmt.Errorf("failed to write file at %s: %w", fileName, err), "unable to write file")
+	}
+
+	// match the permissions os.Create would have given the file
+	err = file.Chmod(0644)
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = os.Rename(tempName, fileName)
+	}
+
+	if err != nil {
+		return &httpError{status: internal_server_error, message: "unable to write file", err: fmt.Errorf("failed to store file at %s: %w", fileName, err)}
+	}
+
+	renamed = true
+
+	if c.quota != nil {
+		c.quota.adjust(written - existingSize)
+	}
+
+	responseType := created
+	if method == "PUT" && existed {
+		responseType = ok
+	}
+
+	if err := c.send(
+		ctx,
+		buildResponse(
+			responseType,
+			nil,
+			"",
+		),
+	); err != nil {
+		return fmt.Errorf("failed to send OK response for %s request", method)
+	}
+
+	return nil
+}
+
+// handleDelete removes the file or directory at the path under filesDir. A
+// body sent along is never read, handleRequest drains it afterwards like any
+// other unread body so the next request on the connection is parsed from the
+// right place.
+func (c *connection) handleDelete(ctx context.Context, request *request) error {
+	startLine := request.protocol
+	path := strings.Split(startLine, " ")[1]
+	pathSplit := strings.Split(path, "/")
+
+	if len(pathSplit) < 2 || pathSplit[1] != "files" {
+		return newHTTPError(not_found, "")
+	}
+
+	if err := c.checkWritable(request); err != nil {
+		return err
+	}
+
+	// the path is cleaned against the root rather than resolved, so deleting a
+	// symlink removes the link and never its target
+	fileName := c.filePath(strings.Join(pathSplit[2:], "/"))
+
+	if filepath.Clean(fileName) == filepath.Clean(c.filesDir) {
+		if c.adminToken != "" {
+			return c.clearFiles(ctx, request)
+		}
+
+		return newHTTPError(forbidden, "refusing to delete the files directory")
+	}
+
+	fileInfo, err := os.Lstat(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to get file info for file name %s: %w", fileName, err)
+	}
+
+	if !preconditionsMet(request, fileInfo) {
+		return newHTTPError(precondition_failed, "file was modified")
+	}
+
+	if err != nil {
+		return newHTTPError(not_found, "")
+	}
+
+	remove := os.Remove
+	if fileInfo.IsDir() {
+		if !strings.EqualFold(request.headers["X-Recursive"], "true") {
+			return newHTTPError(conflict, "deleting a directory requires X-Recursive: true")
+		}
+
+		remove = os.RemoveAll
+	}
+
+	if err := remove(fileName); err != nil {
+		err = fmt.Errorf("failed to delete %s: %w", fileName, err)
+		if errors.Is(err, fs.ErrPermission) {
+			return &httpError{status: forbidden, message: "file is not deletable", err: err}
+		}
+
+		return &httpError{status: internal_server_error, message: "unable to delete file", err: err}
+	}
+
+	if c.quota != nil {
+		if fileInfo.IsDir() {
+			c.rescanQuota()
+		} else if fileInfo.Mode().IsRegular() {
+			c.quota.adjust(-fileInfo.Size())
+		}
+	}
+
+	if err := c.send(ctx, buildResponse(no_content, nil, "")); err != nil {
+		return fmt.Errorf("failed to send NO CONTENT response for DELETE request: %w", err)
+	}
+
+	return nil
+}
+
+func (c *connection) handleTrace(ctx context.Context, request *request) error {
+	// a TRACE request must not carry a body
+	if _, ok := request.headers["Content-Length"]; ok {
+		return newHTTPError(bad_request, "TRACE requests must not have a body")
+	}
+
+	names := make([]string, 0, len(request.headers))
+	for name := range request.headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var builder strings.Builder
+
+	builder.WriteString(request.protocol + "\r\n")
+	for _, name := range names {
+		builder.WriteString(name + ": " + request.headers[name] + "\r\n")
+	}
+	builder.WriteString("\r\n")
+
+	content := builder.String()
+	headers := []string{
+		"Content-Type: message/http",
+		fmt.Sprintf("Content-Length: %d", len(content)),
+	}
+
+	if err := c.send(ctx, buildResponse(ok, &headers, content)); err != nil {
+		return fmt.Errorf("failed to send TRACE response: %w", err)
+	}
+
+	return nil
+}
+
+func (c *connection) logAccess(ctx context.Context, request *request) {
+	entry := accessEntry{
+		Status:     c.status,
+		Bytes:      c.written,
+		DurationMs: float64(time.Since(requestStart(ctx)).Microseconds()) / 1000,
+		RemoteAddr: c.clientAddr(request),
+		RequestID:  requestID(ctx),
+	}
+
+	if user, ok := authenticatedUser(ctx); ok {
+		entry.User = user
+	}
+
+	if request != nil && request.preview != nil && len(request.preview.preview) != 0 {
+		entry.BodyPreview = escapePreview(request.preview.preview)
+	}
+
+	if request != nil {
+		requestLine := strings.Split(request.protocol, " ")
+		entry.Method = requestLine[0]
+		if len(requestLine) > 1 {
+			entry.Path = requestLine[1]
+		}
+	}
+
+	// browsers ask for a favicon on every page load, when there's none the
+	// 404s would only clutter the log
+	level := levelInfo
+	if entry.Path == "/favicon.ico" && entry.Status == 404 && c.favicon == nil {
+		level = levelDebug
+	}
+
+	if logger.enabled(level) {
+		c.accessLog.log(entry)
+	}
+}
+
+// validRequestLine reports whether requestLine has the form
+// "METHOD target HTTP/version" with a target in origin-form, or the
+// asterisk-form for OPTIONS.
+func validRequestLine(requestLine string) bool {
+	parts := strings.Split(requestLine, " ")
+	if len(parts) != 3 || parts[0] == "" || !strings.HasPrefix(parts[2], "HTTP/") {
+		return false
+	}
+
+	target := parts[1]
+
+	return strings.HasPrefix(target, "/") || (target == "*" && parts[0] == "OPTIONS")
+}
+
+// keepAlive reports whether the connection may be reused after responding to
+// request. HTTP/1.1 connections are persistent unless the client asks to close
+// them, HTTP/1.0 ones only when the client asks to keep them open.
+func keepAlive(request *request) bool {
+	connection := strings.ToLower(request.headers["Connection"])
+
+	if strings.HasSuffix(request.protocol, "HTTP/1.0") {
+		return strings.Contains(connection, "keep-alive")
+	}
+
+	return !strings.Contains(connection, "close")
+}
+
+// handle serves requests on the connection until the client closes it or asks
+// for it to be closed, it stays idle for longer than the idle timeout, or a
+// request fails.
+func (c *connection) handle() error {
+	for {
+		// waiting for the next request is bounded by the idle timeout, the
+		// request timeout only starts once the client begins sending
+		c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+
+		if _, err := c.reader.Peek(1); err != nil {
+			var netErr net.Error
+			if err == io.EOF {
+				logger.debugf("Connection from %s closed by client", c.conn.RemoteAddr())
+				return nil
+			}
+
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				logger.debugf("Connection from %s idle for %v, closing", c.conn.RemoteAddr(), c.idleTimeout)
+				return nil
+			}
+
+			return fmt.Errorf("failed waiting for request: %w", err)
+		}
+
+		reuse, err := c.handleRequest()
+		c.served++
+
+		if err != nil {
+			return err
+		}
+
+		// pipelined requests are answered strictly in order, one response
+		// has to be completely written before the next request is read
+		if c.writer.Buffered() != 0 {
+			if err := c.writer.Flush(); err != nil {
+				return fmt.Errorf("failed to flush response: %w", err)
+			}
+		}
+
+		if !reuse || c.lifecycle.draining.Load() {
+			return nil
+		}
+	}
+}
+
+func (c *connection) handleRequest() (reuse bool, err error) {
+	start := time.Now()
+	c.status, c.written = 0, 0
+	c.expectContinue = nil
+
+	base := withRequest(context.Background(), newRequestID(), start)
+	ctx, cancel := c.requestContext(base, start, c.timeout)
+
+	// cancel is replaced when the route has a timeout of its own
+	defer func() { cancel() }()
+
+	var request *request
+
+	defer func() {
+		// nothing to log if the client went away without sending a request
+		if request != nil || c.status != 0 {
+			c.logAccess(ctx, request)
+		}
+	}()
+
+	defer func() {
+		if err == nil || c.maxDuration <= 0 || time.Since(start) < c.maxDuration {
+			return
+		}
+
+		reuse = false
+
+		// the response can only be replaced if none of it has been sent
+		if c.status != 0 {
+			return
+		}
+
+		sendCtx, cancelSend := context.WithTimeout(context.Background(), time.Second)
+		defer cancelSend()
+
+		c.send(sendCtx, c.errorResponse(ctx, service_unavailable, nil, "request took too long"))
+	}()
+
+	request, err = c.receive(ctx)
+	if err != nil {
+		// the connection is closed after any error response
+		c.connectionHeaders = []string{"Connection: close"}
+
+		switch {
+		case errors.Is(err, errHTTP2Preface):
+			// a client speaking HTTP/2 right away isn't malformed, just
+			// asking for a protocol the server doesn't implement
+			logger.debugf("Rejecting HTTP/2 connection from %s", c.conn.RemoteAddr())
+			if err := c.send(ctx, c.errorResponse(ctx, version_not_supported, nil, "HTTP/2 is not supported, use HTTP/1.1")); err != nil {
+				return false, fmt.Errorf("failed to send HTTP VERSION NOT SUPPORTED response: %w", err)
+			}
+
+			return false, nil
+		case errors.Is(err, errRequestLineTooLong):
+			if err := c.send(ctx, c.errorResponse(ctx, uri_too_long, nil, "")); err != nil {
+				return false, fmt.Errorf("failed to send URI TOO LONG response: %w", err)
+			}
+		case errors.Is(err, errMalformedRequest):
+			if err := c.send(ctx, c.errorResponse(ctx, bad_request, nil, "")); err != nil {
+				return false, fmt.Errorf("failed to send BAD REQUEST response: %w", err)
+			}
+		case errors.Is(err, errRequestTooLarge):
+			if err := c.send(ctx, c.errorResponse(ctx, payload_too_large, nil, "")); err != nil {
+				return false, fmt.Errorf("failed to send PAYLOAD TOO LARGE response: %w", err)
+			}
+		}
+
+		return false, fmt.Errorf("failed to receive request: %w", err)
+	}
+
+	if !validRequestLine(request.protocol) {
+		// the connection is closed after the 400, the client has to know
+		c.connectionHeaders = []string{"Connection: close"}
+		if err := c.send(ctx, c.errorResponse(ctx, bad_request, nil, "malformed request line")); err != nil {
+			return false, fmt.Errorf("failed to send BAD REQUEST response for malformed request line: %w", err)
+		}
+
+		return false, fmt.Errorf("malformed request line: %q", request.protocol)
+	}
+
+	requestLine := strings.Split(request.protocol, " ")
+
+	// a route with a timeout of its own gets it counted from the start of the
+	// request, the head was still received within -timeout
+	if timeout, ok := c.routeTimeouts.lookup(requestLine[1]); ok {
+		cancel()
+		ctx, cancel = c.requestContext(base, start, timeout)
+
+		// the body is read under the deadline receive set, without a body
+		// there's none to move
+		if request.contentLength != 0 {
+			deadline, _ := ctx.Deadline()
+			c.conn.SetReadDeadline(deadline)
+		}
+	}
+
+	ctx = withTarget(ctx, requestLine[0], requestLine[1])
+
+	c.current.Store(request.protocol)
+	defer c.current.Store("")
+
+	// HTTP/1.0 clients don't know about 100 Continue, so there's nothing they
+	// could be expecting
+	if expect, ok := request.headers["Expect"]; ok && strings.HasSuffix(request.protocol, "HTTP/1.1") {
+		if !strings.EqualFold(strings.TrimSpace(expect), "100-continue") {
+			c.connectionHeaders = []string{"Connection: close"}
+			if err := c.send(ctx, c.errorResponse(ctx, expectation_failed, nil, "")); err != nil {
+				return false, fmt.Errorf("failed to send EXPECTATION FAILED response: %w", err)
+			}
+
+			return false, nil
+		}
+
+		if request.contentLength != 0 {
+			c.expectContinue = &continueReader{ctx: ctx, c: c, reader: request.body}
+			request.body = c.expectContinue
+		}
+	}
+
+	if c.logBody {
+		request.preview = &previewReader{reader: request.body}
+		request.body = request.preview
+	}
+
+	reuse = keepAlive(request)
+	c.connectionHeaders = nil
+
+	if c.maxRequests > 0 {
+		remaining := c.maxRequests - c.served - 1
+		if remaining <= 0 {
+			reuse = false
+		} else {
+			c.connectionHeaders = []string{fmt.Sprintf("Keep-Alive: timeout=%d, max=%d", int(c.idleTimeout.Seconds()), remaining)}
+		}
+	}
+
+	if !reuse {
+		c.connectionHeaders = []string{"Connection: close"}
+	}
+
+	if user, ok := c.authenticate(request); ok {
+		ctx = withUser(ctx, user)
+	}
+
+	if err := c.dispatch(ctx, request); err != nil {
+		// what's left of a body that couldn't be read can't be told apart
+		// from the next request
+		unreadable := errors.Is(err, errMalformedRequest) || errors.Is(err, errRequestTooLarge)
+		if unreadable {
+			c.connectionHeaders = []string{"Connection: close"}
+		}
+
+		if err := c.respondError(ctx, err); err != nil {
+			return false, err
+		}
+
+		if unreadable {
+			return false, nil
+		}
+	}
+
+	// a body the client was never asked for may or may not follow, so there's
+	// no telling where the next request starts
+	if c.expectContinue.awaiting() {
+		return false, nil
+	}
+
+	// whatever the handler didn't read of the body is still on the wire
+	if !request.drainBody() {
+		return false, nil
+	}
+
+	// the next request on this connection gets a deadline of its own
+	c.conn.SetReadDeadline(time.Time{})
+
+	return reuse && !c.upgraded, nil
+}
+
+// requestContext bounds a request that started at start by timeout, and by
+// maxDuration when it's set.
+func (c *connection) requestContext(base context.Context, start time.Time, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithDeadline(base, start.Add(timeout))
+
+	// the hard cap covers the whole request regardless of how the time is
+	// split between reading, handling and writing
+	if c.maxDuration > 0 {
+		capped, cancelMax := context.WithDeadline(ctx, start.Add(c.maxDuration))
+		return capped, func() {
+			cancelMax()
+			cancel()
+		}
+	}
+
+	return ctx, cancel
+}
+
+// dispatch routes request to the handler for its method.
+func (c *connection) dispatch(ctx context.Context, request *request) error {
+	requestVerb := strings.Split(request.protocol, " ")[0]
+
+	if !c.methodEnabled(requestVerb) {
+		return &httpError{
+			status:  method_not_allowed,
+			headers: []string{"Allow: " + strings.Join(c.enabledMethods(implementedMethods), ", ")},
+		}
+	}
+
+	// requests are only authenticated when an authenticator is configured,
+	// and never for the health checks
+	if _, ok := authenticatedUser(ctx); c.requiresAuth(strings.Split(request.protocol, " ")[1]) && !ok {
+		return &httpError{
+			status:  unauthorized,
+			headers: []string{"WWW-Authenticate: " + c.challenge()},
+		}
+	}
+
+	// the asterisk-form of OPTIONS asks about the server as a whole, there's
+	// no path to route
+	if strings.Split(request.protocol, " ")[1] == "*" {
+		return c.handleServerOptions(ctx)
+	}
+
+	if rule, ok := c.matchRule(requestVerb, strings.Split(request.protocol, " ")[1]); ok {
+		return c.sendRule(ctx, request, rule)
+	}
+
+	switch requestVerb {
+	case "GET":
+		if err := c.handleGet(ctx, request); err != nil {
+			return fmt.Errorf("failed to handle GET request: %w", err)
+		}
+	case "HEAD":
+		if err := c.handleGet(ctx, request); err != nil {
+			return fmt.Errorf("failed to handle HEAD request: %w", err)
+		}
+	case "POST":
+		if err := c.handlePost(ctx, request); err != nil {
+			return fmt.Errorf("failed to handle POST request: %w", err)
+		}
+	case "PUT":
+		if err := c.handlePut(ctx, request); err != nil {
+			return fmt.Errorf("failed to handle PUT request: %w", err)
+		}
+	case "DELETE":
+		if err := c.handleDelete(ctx, request); err != nil {
+			return fmt.Errorf("failed to handle DELETE request: %w", err)
+		}
+	case "OPTIONS":
+		if err := c.handleOptions(ctx, request); err != nil {
+			return fmt.Errorf("failed to handle OPTIONS request: %w", err)
+		}
+	case "TRACE":
+		if err := c.handleTrace(ctx, request); err != nil {
+			return fmt.Errorf("failed to handle TRACE request: %w", err)
+		}
+	default:
+		// methodEnabled only lets implemented methods through, this is
+		// for one that's implemented but not routed here
+		return &httpError{
+			status:  method_not_allowed,
+			headers: []string{"Allow: " + strings.Join(c.enabledMethods(implementedMethods), ", ")},
+			err:     fmt.Errorf("no handler for request verb %s", requestVerb),
+		}
+	}
+
+	return nil
+}
+
+func (c *connection) close() {
+	c.registry.remove(c)
+	c.metrics.recordConnection(c.served)
+	c.conn.Close()
+}
+
+func newConnection(conn net.Conn, cfg *config) (*connection, error) {
+	traffic := &countingConn{Conn: conn}
+
+	return &connection{
+		config:   cfg,
+		conn:     traffic,
+		reader:   bufio.NewReader(traffic),
+		writer:   bufio.NewWriter(traffic),
+		accepted: time.Now(),
+		traffic:  traffic,
+	}, nil
+}
+
+func main() {
+	configFlag := flag.String("config", "", "path to a key=value or JSON file of flag values; command-line flags take precedence")
+	dirFlag := flag.String("directory", ".", "directory to serve files from")
+	zipFlag := flag.String("zip", "", "serve /files read only from this zip archive instead of the directory")
+	serveStdinFlag := flag.String("serve-stdin", "", "read stdin on startup and serve it at this path under /files")
+	stdinTypeFlag := flag.String("stdin-content-type", "", "Content-Type of the -serve-stdin file, guessed from its name and content when empty")
+	portFlag := flag.Int("port", defaultPort, "port to listen on")
+	listenFDFlag := flag.Int("listen-fd", 0, "accept connections on the listening socket inherited as this fd, e.g. 3 with systemd socket activation, instead of binding -port")
+	timeoutFlag := flag.Duration("timeout", defaultTimeout, "time allowed to receive and respond to a request")
+	shutdownDelayFlag := flag.Duration("shutdown-delay", 0, "time to keep accepting connections while reporting not ready after a shutdown signal")
+	drainTimeoutFlag := flag.Duration("drain-timeout", 10*time.Second, "time to wait for open connections to finish on shutdown")
+	maxDurationFlag := flag.Duration("max-request-duration", 0, "hard cap on the total time spent on a request, 0 for no cap")
+	idleTimeoutFlag := flag.Duration("idle-timeout", defaultIdleTimeout, "time a keep-alive connection may wait for its next request")
+	headerTimeoutFlag := flag.Duration("header-timeout", 0, "time allowed to receive the request line and headers, 0 for the -timeout")
+	headerLineTimeoutFlag := flag.Duration("header-line-timeout", 0, "time allowed between lines of the request headers, 0 for no limit")
+	createDirFlag := flag.Bool("create-dir", true, "create the directory if it does not exist")
+	tcpKeepAliveFlag := flag.Duration("tcp-keepalive", 15*time.Second, "period of TCP keep-alive probes on idle connections, 0 disables them")
+	acceptBackoffFlag := flag.Duration("accept-backoff", time.Second, "longest wait between retries when accepting a connection fails temporarily")
+	maxConnsFlag := flag.Int("max-conns", 0, "maximum open connections, 0 for unlimited")
+	overloadModeFlag := flag.String("overload-mode", "block", "what happens to new connections beyond -max-conns, block waits for one to close and reject answers 503 (block|reject)")
+	maxConnsPerIPFlag := flag.Int("max-conns-per-ip", 0, "maximum open connections from a single client IP, further ones get 503, 0 for unlimited")
+	maxRequestsFlag := flag.Int("max-requests-per-conn", 0, "close keep-alive connections after this many requests, 0 for unlimited")
+	maxRequestSizeFlag := flag.Int64("max-request-size", 0, "maximum bytes in a request's line, headers and body together, 0 for unlimited")
+	maxRequestLineFlag := flag.Int("max-request-line", defaultMaxRequestLine, "maximum length in bytes of the request line")
+	streamChunkSizeFlag := flag.Int("stream-chunk-size", defaultStreamChunkSize, "bytes read and written at a time when streaming files and proxied responses")
+	rangesFlag := flag.Bool("ranges", true, "serve byte ranges of files when requested")
+	contentMD5Flag := flag.Bool("content-md5", false, "send the MD5 of served files in a Content-MD5 header")
+	requireUserAgentFlag := flag.Bool("require-user-agent", false, "answer /user-agent requests without a User-Agent header with 400 instead of an empty body")
+	gzipMinSizeFlag := flag.Int("gzip-min-size", 1024, "smallest body in bytes that -gzip compresses")
+	emptyAs204Flag := flag.Bool("empty-as-204", false, "answer 204 No Content instead of 200 when the body would be empty")
+	gzipFlag := flag.Bool("gzip", false, "gzip /echo and /files responses for clients that accept it, range requests are served uncompressed")
+	faviconFlag := flag.String("favicon", "", "icon file served for /favicon.ico, or builtin for a default one, /favicon.ico is a 404 when empty")
+	preloadFlag := flag.String("preload", "", "comma separated files, relative to the directory, read into memory on startup and served from there while unchanged")
+	indexFlag := flag.String("index", "index.html", "comma separated file names, in order of priority, served for a request to a directory")
+	spaFlag := flag.Bool("spa", false, "serve -spa-entry for missing files without an extension, for client side routing")
+	spaEntryFlag := flag.String("spa-entry", "index.html", "file served by -spa, relative to the directory")
+	followSymlinksFlag := flag.Bool("follow-symlinks", false, "serve files through symlinks as long as they resolve inside the directory")
+	trustProxyFlag := flag.Bool("trust-proxy", false, "use the Forwarded for= address, X-Forwarded-For or X-Real-IP as the client address")
+	cacheControl := &cacheControlRules{}
+	flag.Var(cacheControl, "cache-control", "Cache-Control for served files as .ext=value rules separated by ';', a bare value is the default (repeatable)")
+	var routes routeTimeouts
+	flag.Var(&routes, "route-timeout", "request timeout for a path prefix and the paths below it as /prefix=duration, in place of -timeout (repeatable)")
+	var latency latencyRange
+	flag.Var(&latency, "inject-latency", "delay every response by a duration, or a random one in a range like 10ms-50ms, for testing, shorter than -timeout")
+	var hints earlyHints
+	flag.Var(&defaultHeaders, "header", "header as Name: value added to every response that doesn't set it already (repeatable)")
+	flag.Var(&hints, "early-hints", "Link header value sent in a 103 Early Hints response before HTML files (repeatable)")
+	authFlag := flag.String("auth", "", "require basic auth with the single user given as user:password")
+	authFileFlag := flag.String("auth-file", "", "require basic auth against the users of an htpasswd file ({SHA} hashes only, bcrypt is not supported)")
+	bearerTokenFlag := flag.String("bearer-token", "", "require an Authorization: Bearer header with this token")
+	adminTokenFlag := flag.String("admin-token", "", "allow DELETE /files with this token in X-Admin-Token to remove every file in the directory, for test setups")
+	methodsFlag := flag.String("methods", strings.Join(implementedMethods, ","), "comma separated methods to serve, others are answered with 405")
+	disableTraceFlag := flag.Bool("disable-trace", false, "reject TRACE requests with 405")
+	quotaFlag := flag.Int64("quota", 0, "maximum total bytes stored in the directory by uploads, 0 for no quota")
+	idempotencyTTLFlag := flag.Duration("idempotency-ttl", 0, "remember POST results by Idempotency-Key for this long, 0 disables")
+	rulesFlag := flag.String("rules", "", "JSON file of canned responses sent for matching requests instead of handling them, for testing clients")
+	debugFlag := flag.Bool("debug", false, "serve the open connections as JSON on /debug/connections, not for production")
+	devFlag := flag.Bool("dev", false, "log every served file and disable caching headers, for local development")
+	upstreamFlag := flag.String("upstream", "", "URL that requests under /proxy/ are forwarded to, proxying is disabled when empty")
+	upstreamTimeoutFlag := flag.Duration("upstream-timeout", 3*time.Second, "time allowed for the upstream to accept a proxied request and send its response headers, the body may take as long as the request")
+	bannerFlag := flag.Bool("banner", true, "log the effective configuration on startup")
+	verboseFlag := flag.Bool("verbose", false, "include every setting in the startup banner")
+	errorFormatFlag := flag.String("error-format", "text", "body format of error responses (text|json)")
+	logFormatFlag := flag.String("log-format", "text", "access log format (text|json)")
+	logBodyFlag := flag.Bool("log-body", false, "include an escaped preview of the first 100 bytes of request bodies in the access log")
+	logLevelFlag := flag.String("log-level", "info", "log verbosity (error|warn|info|debug)")
+
+	flag.Parse()
+
+	if *configFlag != "" {
+		if err := loadConfigFile(flag.CommandLine, *configFlag); err != nil {
+			logger.errorf("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	level, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		logger.errorf("%v", err)
+		os.Exit(1)
+	}
+
+	logger.level = level
+
+	accessLog, err := newAccessLogger(*logFormatFlag, os.Stdout)
+	if err != nil {
+		logger.errorf("%v", err)
+		os.Exit(1)
+	}
+
+	if *timeoutFlag <= 0 || *idleTimeoutFlag <= 0 || *maxDurationFlag < 0 || *headerTimeoutFlag < 0 || *headerLineTimeoutFlag < 0 || *upstreamTimeoutFlag <= 0 || *acceptBackoffFlag <= 0 || *tcpKeepAliveFlag < 0 {
+		logger.errorf("Timeouts must be positive")
+		os.Exit(1)
+	}
+
+	// a delay that outlasts the request would leave nothing to respond with
+	if latency.max >= *timeoutFlag {
+		logger.errorf("Injected latency of up to %v must be shorter than the request timeout of %v", latency.max, *timeoutFlag)
+		os.Exit(1)
+	}
+
+	if *overloadModeFlag != "block" && *overloadModeFlag != "reject" {
+		logger.errorf("Unknown overload mode %q, expected block or reject", *overloadModeFlag)
+		os.Exit(1)
+	}
+
+	if *errorFormatFlag != "text" && *errorFormatFlag != "json" {
+		logger.errorf("Unknown error format %q, expected text or json", *errorFormatFlag)
+		os.Exit(1)
+	}
+
+	if *streamChunkSizeFlag < minStreamChunkSize || *streamChunkSizeFlag > maxStreamChunkSize {
+		logger.errorf("Stream chunk size must be between %d and %d bytes", minStreamChunkSize, maxStreamChunkSize)
+		os.Exit(1)
+	}
+
+	if *maxRequestLineFlag <= 0 {
+		logger.errorf("Maximum request line length must be positive")
+		os.Exit(1)
+	}
+
+	var authenticator Authenticator
+
+	configured := 0
+	for _, value := range []string{*authFlag, *authFileFlag, *bearerTokenFlag} {
+		if value != "" {
+			configured++
+		}
+	}
+
+	switch {
+	case configured > 1:
+		logger.errorf("Only one of -auth, -auth-file and -bearer-token may be set")
+		os.Exit(1)
+	case *authFlag != "":
+		authenticator, err = newStaticAuthenticator(*authFlag)
+	case *authFileFlag != "":
+		authenticator, err = newHtpasswdAuthenticator(*authFileFlag)
+	case *bearerTokenFlag != "":
+		authenticator, err = newBearerAuthenticator(*bearerTokenFlag)
+	}
+
+	if err != nil {
+		logger.errorf("Failed to configure authentication: %v", err)
+		os.Exit(1)
+	}
+
+	var devFiles *servedFiles
+	if *devFlag {
+		devFiles = newServedFiles()
+	}
+
+	methods, err := parseMethods(*methodsFlag)
+	if err != nil {
+		logger.errorf("Invalid -methods: %v", err)
+		os.Exit(1)
+	}
+
+	var digests *digestCache
+	if *contentMD5Flag {
+		digests = newDigestCache()
+	}
+
+	var spaEntry string
+	if *spaFlag {
+		spaEntry = fsPath(*spaEntryFlag)
+	}
+
+	var upstream *url.URL
+	if *upstreamFlag != "" {
+		upstream, err = parseUpstream(*upstreamFlag)
+		if err != nil {
+			logger.errorf("Invalid upstream: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var rules []responseRule
+	if *rulesFlag != "" {
+		rules, err = loadRules(*rulesFlag)
+		if err != nil {
+			logger.errorf("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	var idempotency *idempotencyStore
+	if *idempotencyTTLFlag > 0 {
+		idempotency = newIdempotencyStore(*idempotencyTTLFlag)
+	}
+
+	var files fs.FS = os.DirFS(*dirFlag)
+	if *zipFlag != "" {
+		archive, err := openZip(*zipFlag)
+		if err != nil {
+			logger.errorf("%v", err)
+			os.Exit(1)
+		}
+
+		files = archive
+	}
+
+	var stdinName string
+	if *serveStdinFlag != "" {
+		stdinName = fsPath(*serveStdinFlag)
+		if stdinName == "." {
+			logger.errorf("-serve-stdin needs a file name")
+			os.Exit(1)
+		}
+
+		stdin, err := readStdin(files, stdinName)
+		if err != nil {
+			logger.errorf("%v", err)
+			os.Exit(1)
+		}
+
+		files = stdin
+	}
+
+	var favicon []byte
+	if *faviconFlag != "" {
+		favicon, err = loadFavicon(*faviconFlag)
+		if err != nil {
+			logger.errorf("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	var preloaded *preloadCache
+	if *preloadFlag != "" {
+		preloaded, err = loadPreload(files, *preloadFlag)
+		if err != nil {
+			logger.errorf("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	var registry *connRegistry
+	if *debugFlag {
+		registry = newConnRegistry()
+	}
+
+	cfg := &config{
+		filesDir:          *dirFlag,
+		files:             files,
+		zipPath:           *zipFlag,
+		stdinName:         stdinName,
+		stdinType:         *stdinTypeFlag,
+		timeout:           *timeoutFlag,
+		idleTimeout:       *idleTimeoutFlag,
+		headerTimeout:     *headerTimeoutFlag,
+		headerLineTimeout: *headerLineTimeoutFlag,
+		maxDuration:       *maxDurationFlag,
+		maxRequestLine:    *maxRequestLineFlag,
+		maxRequests:       *maxRequestsFlag,
+		maxRequestSize:    *maxRequestSizeFlag,
+		ranges:            *rangesFlag,
+		gzip:              *gzipFlag,
+		gzipMinSize:       *gzipMinSizeFlag,
+		chunkSize:         *streamChunkSizeFlag,
+		emptyAs204:        *emptyAs204Flag,
+		requireUA:         *requireUserAgentFlag,
+		errorFormat:       *errorFormatFlag,
+		logBody:           *logBodyFlag,
+		followSymlinks:    *followSymlinksFlag,
+		methods:           methods,
+		digests:           digests,
+		preloaded:         preloaded,
+		favicon:           favicon,
+		registry:          registry,
+		indexFiles:        parseIndexFiles(*indexFlag),
+		spaEntry:          spaEntry,
+		trustProxy:        *trustProxyFlag,
+		disableTrace:      *disableTraceFlag,
+		cacheControl:      cacheControl,
+		earlyHints:        hints,
+		latency:           latency,
+		routeTimeouts:     routes,
+		authenticator:     authenticator,
+		accessLog:         accessLog,
+		devFiles:          devFiles,
+		idempotency:       idempotency,
+		uploads:           newUploadTracker(),
+		adminToken:        *adminTokenFlag,
+		rules:             rules,
+		metrics:           newMetrics(),
+		upstream:          upstream,
+		upstreamTimeout:   *upstreamTimeoutFlag,
+		lifecycle:         &lifecycle{},
+	}
+
+	if *createDirFlag {
+		if err := os.MkdirAll(*dirFlag, 0755); err != nil {
+			logger.errorf("Failed to create directory")
+			os.Exit(1)
+		}
+	}
+
+	dirInfo, err := os.Stat(*dirFlag)
 	if err != nil {
-		fmt.Println("Failed to bind to port 4221")
+		logger.errorf("Failed to access directory %s: %v", *dirFlag, err)
+		os.Exit(1)
+	}
+
+	if !dirInfo.IsDir() {
+		logger.errorf("Path %s is not a directory", *dirFlag)
 		os.Exit(1)
 	}
 
+	if *quotaFlag > 0 {
+		cfg.quota, err = newQuota(*dirFlag, *quotaFlag)
+		if err != nil {
+			logger.errorf("Failed to compute directory size for quota: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var l net.Listener
+	if *listenFDFlag > 0 {
+		l, err = inheritListener(*listenFDFlag)
+		if err != nil {
+			logger.errorf("Failed to listen on fd %d: %v", *listenFDFlag, err)
+			os.Exit(1)
+		}
+	} else {
+		l, err = net.Listen("tcp", fmt.Sprintf("localhost:%d", *portFlag))
+		if err != nil {
+			logger.errorf("Failed to bind to port %d", *portFlag)
+			os.Exit(1)
+		}
+	}
+
+	var conns *connLimiter
+	if *maxConnsFlag > 0 {
+		conns = newConnLimiter(*maxConnsFlag)
+	}
+
+	// in block mode a full server stops accepting and lets further clients
+	// wait in the listen backlog
+	blockOnFull := conns != nil && *overloadModeFlag == "block"
+
+	var perIP *ipLimiter
+	if *maxConnsPerIPFlag > 0 {
+		perIP = newIPLimiter(*maxConnsPerIPFlag)
+	}
+
+	if *bannerFlag {
+		cfg.logBanner(l.Addr().String(), *verboseFlag)
+	}
+
+	cfg.lifecycle.shutdownOnSignal(l, *shutdownDelayFlag)
+	cfg.lifecycle.ready.Store(true)
+
+	var backoff time.Duration
+
 	for {
+		if blockOnFull {
+			conns.acquire()
+		}
+
 		conn, err := l.Accept()
 		if err != nil {
-			fmt.Println("Failed to accept client connection")
+			if blockOnFull {
+				conns.release()
+			}
+
+			if errors.Is(err, net.ErrClosed) && cfg.lifecycle.draining.Load() {
+				break
+			}
+
+			// running out of file descriptors and the like pass once some
+			// connections close, so back off and retry rather than exit
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				if backoff == 0 {
+					backoff = minAcceptBackoff
+				} else if backoff *= 2; backoff > *acceptBackoffFlag {
+					backoff = *acceptBackoffFlag
+				}
+
+				logger.warnf("Failed to accept client connection, retrying in %v: %v", backoff, err)
+				time.Sleep(backoff)
+				continue
+			}
+
+			logger.errorf("Failed to accept client connection: %v", err)
 			os.Exit(1)
 		}
 
-		c, err := newConnection(conn, *dirFlag)
+		backoff = 0
+
+		// TCP keep-alive probes stop NATs from silently dropping idle
+		// connections, unlike HTTP keep-alive which reuses them
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetKeepAlive(*tcpKeepAliveFlag > 0)
+			if *tcpKeepAliveFlag > 0 {
+				tcpConn.SetKeepAlivePeriod(*tcpKeepAliveFlag)
+			}
+		}
+
+		// in reject mode a full server answers right away, without reading
+		// the request
+		if conns != nil && !blockOnFull && !conns.tryAcquire() {
+			logger.warnf("Refusing connection from %s, the server is at -max-conns", remoteIP(conn))
+			go refuse(conn, "server is at capacity")
+			continue
+		}
+
+		ip := remoteIP(conn)
+		if perIP != nil && !perIP.acquire(ip) {
+			if conns != nil {
+				conns.release()
+			}
+
+			logger.warnf("Refusing connection from %s, it has too many open", ip)
+			go refuse(conn, "too many connections")
+			continue
+		}
+
+		c, err := newConnection(conn, cfg)
 		if err != nil {
-			fmt.Println("Failed to create new connection")
+			logger.errorf("Failed to create new connection")
 			os.Exit(1)
 		}
 
+		cfg.lifecycle.connections.Add(1)
+		cfg.registry.add(c)
+
 		go func() {
+			defer cfg.lifecycle.connections.Done()
 			defer c.close()
 
+			if conns != nil {
+				defer conns.release()
+			}
+
+			if perIP != nil {
+				defer perIP.release(ip)
+			}
+
 			err := c.handle()
 			if err != nil {
-				fmt.Printf("Failed to handle connection: %v\n", err)
+				if isClientAbort(err) {
+					logger.debugf("Client aborted connection: %v", err)
+					return
+				}
+
+				logger.errorf("Failed to handle connection: %v", err)
 				return
 			}
 		}()
 	}
+
+	if !cfg.lifecycle.drain(*drainTimeoutFlag) {
+		logger.warnf("Timed out waiting for connections to finish")
+	}
 }
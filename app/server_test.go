@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestRoot(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 200 {
+		t.Fatalf("got status %d, want 200", response.status)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "GET /echo/abc HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 200 || response.body != "abc" {
+		t.Fatalf("got %d %q, want 200 \"abc\"", response.status, response.body)
+	}
+
+	if got := response.header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("got Content-Type %q, want text/plain", got)
+	}
+}
+
+func TestUnknownPathNotFound(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "GET /nowhere HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 404 {
+		t.Fatalf("got status %d, want 404", response.status)
+	}
+}
+
+func TestPipelinedRequestsAnsweredInOrder(t *testing.T) {
+	cfg := testConfig(t)
+	writeFile(t, cfg, "a.txt", "first file")
+
+	output, err := exchange(t, cfg, "GET /echo/one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"HEAD /echo/three HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if err != nil {
+		t.Fatalf("handle failed: %v", err)
+	}
+
+	responses := parseResponses(t, output, "GET", "GET", "HEAD")
+
+	for i, want := range []string{"one", "first file", ""} {
+		if responses[i].status != 200 || responses[i].body != want {
+			t.Errorf("response %d: got %d %q, want 200 %q", i+1, responses[i].status, responses[i].body, want)
+		}
+	}
+}
+
+func TestConnectionCloseEndsConnection(t *testing.T) {
+	output, _ := exchange(t, testConfig(t), "GET /echo/one HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"+
+		"GET /echo/two HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	// a second response left in output fails parseResponses
+	response := parseResponses(t, output, "GET")[0]
+
+	if !response.close {
+		t.Errorf("response without Connection: close")
+	}
+}
+
+func TestPipeConnectionKeepAlive(t *testing.T) {
+	client, reader := dial(t, testConfig(t))
+
+	for _, word := range []string{"one", "two"} {
+		go client.Write([]byte("GET /echo/" + word + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+		if response := readResponse(t, reader, "GET"); response.body != word {
+			t.Fatalf("got body %q, want %q", response.body, word)
+		}
+	}
+}
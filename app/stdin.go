@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// stdinFS serves the content read from stdin with -serve-stdin as the file
+// name, on top of the files that are served otherwise.
+type stdinFS struct {
+	fs.FS
+	name    string
+	content []byte
+	modTime time.Time
+}
+
+// readStdin reads all of stdin to be served as name under /files.
+func readStdin(files fs.FS, name string) (*stdinFS, error) {
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	return &stdinFS{FS: files, name: name, content: content, modTime: time.Now()}, nil
+}
+
+func (s *stdinFS) Open(name string) (fs.File, error) {
+	if name != s.name {
+		return s.FS.Open(name)
+	}
+
+	return &stdinFile{Reader: bytes.NewReader(s.content), info: stdinInfo{s}}, nil
+}
+
+type stdinFile struct {
+	*bytes.Reader
+	info stdinInfo
+}
+
+func (f *stdinFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *stdinFile) Close() error               { return nil }
+
+type stdinInfo struct {
+	fs *stdinFS
+}
+
+func (i stdinInfo) Name() string       { return path.Base(i.fs.name) }
+func (i stdinInfo) Size() int64        { return int64(len(i.fs.content)) }
+func (i stdinInfo) Mode() fs.FileMode  { return 0444 }
+func (i stdinInfo) ModTime() time.Time { return i.fs.modTime }
+func (i stdinInfo) IsDir() bool        { return false }
+func (i stdinInfo) Sys() interface{}   { return nil }
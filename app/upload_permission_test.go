@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadToReadOnlyDirectory(t *testing.T) {
+	cfg := testConfig(t)
+
+	if err := os.Chmod(cfg.filesDir, 0555); err != nil {
+		t.Fatalf("failed to make directory read only: %v", err)
+	}
+
+	t.Cleanup(func() { os.Chmod(cfg.filesDir, 0755) })
+
+	// root writes through the permission bits
+	if probe, err := os.CreateTemp(cfg.filesDir, "probe"); err == nil {
+		probe.Close()
+		t.Skip("directory is still writable, running as root?")
+	}
+
+	response := roundTrip(t, cfg, "POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+
+	if response.status != 403 || response.body != "directory is not writable" {
+		t.Errorf("got %d %q, want 403 \"directory is not writable\"", response.status, response.body)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.filesDir, "a.txt")); err == nil {
+		t.Errorf("file created in a read only directory")
+	}
+}
+
+func TestFailedUploadKeepsConnection(t *testing.T) {
+	cfg := testConfig(t)
+
+	// the directory the file would go in doesn't exist
+	output, _ := exchange(t, cfg, "POST /files/missing/a.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"+
+		"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	responses := parseResponses(t, output, "POST", "GET")
+
+	if responses[0].status != 409 || responses[0].body != "parent directory does not exist" {
+		t.Errorf("got %d %q, want 409 \"parent directory does not exist\"", responses[0].status, responses[0].body)
+	}
+
+	if responses[1].body != "next" {
+		t.Errorf("got %q after the failed upload, want \"next\"", responses[1].body)
+	}
+}
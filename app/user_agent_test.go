@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestUserAgent(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "GET /user-agent HTTP/1.1\r\nHost: localhost\r\nUser-Agent: foobar/1.2.3\r\n\r\n")
+
+	if response.status != 200 || response.body != "foobar/1.2.3" || response.header.Get("Content-Length") != "12" {
+		t.Errorf("got %d %q with Content-Length %q, want 200 \"foobar/1.2.3\"", response.status, response.body, response.header.Get("Content-Length"))
+	}
+}
+
+func TestUserAgentMissing(t *testing.T) {
+	cfg := testConfig(t)
+
+	// the empty body is framed exactly, the pipelined request still gets
+	// its own response
+	output, _ := exchange(t, cfg, "GET /user-agent HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	responses := parseResponses(t, output, "GET", "GET")
+
+	if responses[0].status != 200 || responses[0].body != "" || responses[0].header.Get("Content-Length") != "0" {
+		t.Errorf("got %d %q with Content-Length %q, want 200 with an empty body", responses[0].status, responses[0].body, responses[0].header.Get("Content-Length"))
+	}
+
+	if responses[1].body != "next" {
+		t.Errorf("got %q after /user-agent, want \"next\"", responses[1].body)
+	}
+}
+
+func TestUserAgentRequired(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.requireUA = true
+
+	response := roundTrip(t, cfg, "GET /user-agent HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if response.status != 400 || response.body != "missing User-Agent" {
+		t.Errorf("got %d %q, want 400 \"missing User-Agent\"", response.status, response.body)
+	}
+
+	// an empty value is still a header that was sent
+	response = roundTrip(t, cfg, "GET /user-agent HTTP/1.1\r\nHost: localhost\r\nUser-Agent:\r\n\r\n")
+	if response.status != 200 || response.body != "" {
+		t.Errorf("got %d %q for an empty User-Agent, want 200 with an empty body", response.status, response.body)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+)
+
+// Build information, injected with -ldflags "-X main.version=..." at build
+// time and reported as "dev" otherwise.
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// versionJSON describes the running build for /version.
+func versionJSON() (string, error) {
+	encoded, err := json.Marshal(versionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
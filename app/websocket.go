@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// maxFramePayload bounds the size of a single frame read from the client
+	maxFramePayload = 1 << 20
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+var (
+	errFrameTooBig       = errors.New("websocket frame too big")
+	errUnmaskedFrame     = errors.New("websocket frame from client is not masked")
+	errFragmentedControl = errors.New("websocket control frame is fragmented")
+)
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// isWebSocketUpgrade reports whether request asks to switch the connection to
+// the websocket protocol. That's the only upgrade the server performs, any
+// other Upgrade header is ignored and the request is served over HTTP/1.1 as
+// if it hadn't been sent.
+func isWebSocketUpgrade(request *request) bool {
+	return strings.EqualFold(request.headers["Upgrade"], "websocket") &&
+		strings.Contains(strings.ToLower(request.headers["Connection"]), "upgrade")
+}
+
+func websocketAccept(key string) string {
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// handleWebSocket completes the websocket handshake and then echoes every
+// message the client sends until either side closes the connection. Requests
+// that don't ask for the upgrade at all, or ask for another protocol, are
+// answered with 426, and methods other than GET with 405.
+func (c *connection) handleWebSocket(ctx context.Context, request *request) error {
+	// the handshake has to be a GET, a HEAD can't upgrade the connection
+	if requestLine := strings.Split(request.protocol, " "); requestLine[0] != "GET" {
+		return &httpError{
+			status:  method_not_allowed,
+			headers: []string{"Allow: " + strings.Join(c.allowedMethods(requestLine[1]), ", ")},
+		}
+	}
+
+	if !isWebSocketUpgrade(request) {
+		return &httpError{
+			status:  upgrade_required,
+			headers: []string{"Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 13"},
+		}
+	}
+
+	key := strings.TrimSpace(request.headers["Sec-Websocket-Key"])
+
+	if key == "" || request.headers["Sec-Websocket-Version"] != "13" {
+		return &httpError{status: bad_request, headers: []string{"Sec-WebSocket-Version: 13"}}
+	}
+
+	headers := []string{
+		"Upgrade: websocket",
+		"Connection: Upgrade",
+		"Sec-WebSocket-Accept: " + websocketAccept(key),
+	}
+
+	if err := c.send(ctx, buildResponse(switching_protocols, &headers, "")); err != nil {
+		return fmt.Errorf("failed to send SWITCHING PROTOCOLS response: %w", err)
+	}
+
+	// the connection no longer speaks HTTP, so it can't serve another request
+	c.upgraded = true
+
+	return c.echoFrames()
+}
+
+func (c *connection) echoFrames() error {
+	for {
+		// the session outlives the request timeout, an idle peer is closed
+		// after the idle timeout instead
+		c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+
+		f, err := c.readFrame()
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()):
+				return nil
+			case errors.Is(err, errFrameTooBig):
+				return c.writeClose(closeTooBig)
+			case errors.Is(err, errUnmaskedFrame) || errors.Is(err, errFragmentedControl):
+				return c.writeClose(closeProtocolError)
+			default:
+				return fmt.Errorf("failed to read websocket frame: %w", err)
+			}
+		}
+
+		switch f.opcode {
+		case opClose:
+			// echo the close frame back, including the status code
+			return c.writeFrame(true, opClose, f.payload)
+		case opPing:
+			if err := c.writeFrame(true, opPong, f.payload); err != nil {
+				return fmt.Errorf("failed to send websocket pong: %w", err)
+			}
+		case opPong:
+		case opText, opBinary, opContinuation:
+			if err := c.writeFrame(f.fin, f.opcode, f.payload); err != nil {
+				return fmt.Errorf("failed to echo websocket frame: %w", err)
+			}
+		default:
+			return c.writeClose(closeProtocolError)
+		}
+	}
+}
+
+func (c *connection) readFrame() (*frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    header[0]&0x80 != 0,
+		opcode: header[0] & 0x0F,
+	}
+
+	if !f.fin && f.opcode >= opClose {
+		return nil, errFragmentedControl
+	}
+
+	if header[1]&0x80 == 0 {
+		return nil, errUnmaskedFrame
+	}
+
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return nil, err
+		}
+
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return nil, err
+		}
+
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if length > maxFramePayload {
+		return nil, errFrameTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return nil, err
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return nil, err
+	}
+
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+
+	return f, nil
+}
+
+func (c *connection) writeFrame(fin bool, opcode byte, payload []byte) error {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+
+	header := []byte{first}
+
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+
+	if _, err := c.writer.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := c.writer.Write(payload); err != nil {
+		return err
+	}
+
+	return c.writer.Flush()
+}
+
+func (c *connection) writeClose(code uint16) error {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+
+	return c.writeFrame(true, opClose, payload)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const upgradeHeaders = "Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+
+func TestWebSocketHandshake(t *testing.T) {
+	output, _ := exchange(t, testConfig(t), "GET /ws HTTP/1.1\r\nHost: localhost\r\n"+upgradeHeaders+"\r\n")
+
+	head, _, _ := strings.Cut(output, "\r\n\r\n")
+	if !strings.HasPrefix(head, switching_protocols+"\r\n") {
+		t.Fatalf("got %q, want a 101", head)
+	}
+
+	// the example handshake of RFC 6455
+	if !strings.Contains(head, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=") {
+		t.Errorf("wrong or missing Sec-WebSocket-Accept:\n%s", head)
+	}
+}
+
+func TestWebSocketHeadNotUpgraded(t *testing.T) {
+	// the connection stays HTTP, so the pipelined request is answered
+	output, _ := exchange(t, testConfig(t), "HEAD /ws HTTP/1.1\r\nHost: localhost\r\n"+upgradeHeaders+"\r\n"+
+		"GET /echo/next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	responses := parseResponses(t, output, "HEAD", "GET")
+
+	if responses[0].status != 405 || responses[0].header.Get("Allow") != "GET, OPTIONS, TRACE" {
+		t.Errorf("got %d with Allow %q, want 405 with GET, OPTIONS, TRACE", responses[0].status, responses[0].header.Get("Allow"))
+	}
+
+	if responses[0].header.Get("Sec-WebSocket-Accept") != "" {
+		t.Errorf("HEAD completed the handshake")
+	}
+
+	if responses[1].body != "next" {
+		t.Errorf("got %q after HEAD /ws, want \"next\"", responses[1].body)
+	}
+}
+
+func TestWebSocketWithoutUpgrade(t *testing.T) {
+	response := roundTrip(t, testConfig(t), "GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if response.status != 426 || response.header.Get("Upgrade") != "websocket" {
+		t.Errorf("got %d with Upgrade %q, want 426 asking for websocket", response.status, response.header.Get("Upgrade"))
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"strings"
+)
+
+// openZip opens the archive at path that /files is served from with -zip. The
+// reader is kept open for the lifetime of the server.
+func openZip(path string) (*zip.ReadCloser, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive %s: %w", path, err)
+	}
+
+	return archive, nil
+}
+
+// checkWritable fails with a 405 for a request that would change /files when
+// the files are served from a zip archive.
+func (c *connection) checkWritable(request *request) error {
+	if c.zipPath == "" {
+		return nil
+	}
+
+	path := strings.Split(request.protocol, " ")[1]
+
+	return &httpError{
+		status:  method_not_allowed,
+		message: "files are served read only from a zip archive",
+		headers: []string{"Allow: " + strings.Join(c.allowedMethods(path), ", ")},
+	}
+}